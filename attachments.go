@@ -0,0 +1,121 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// sourceDescription marks the embedded file that holds the letter itself, see EmbedSource
+const sourceDescription = "left source"
+
+// embeddedFile is a file attachment of a pdf
+type embeddedFile struct {
+	Name        string
+	Description string
+	Content     []byte
+}
+
+var pdfEmbeddedFileStream = regexp.MustCompile(`/EF\s*<<\s*/F (\d+) \d+ R`)
+
+// attachments returns the files to embed in the pdf of inputFile: the letter itself if embedSource is set, followed
+// by the files listed in Attachments
+func attachments(inputFile string, embedSource bool, config Config) ([]fpdf.Attachment, error) {
+	var files []fpdf.Attachment
+	if embedSource {
+		source, err := os.ReadFile(inputFile)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fpdf.Attachment{Content: source, Filename: filepath.Base(inputFile), Description: sourceDescription})
+	}
+	for _, attachment := range config.Attachments {
+		content, err := os.ReadFile(attachment)
+		if err != nil {
+			return nil, &configError{Field: "Attachments", Err: describeFileError(attachment, err)}
+		}
+		files = append(files, fpdf.Attachment{Content: content, Filename: filepath.Base(attachment)})
+	}
+	return files, nil
+}
+
+// readEmbeddedFiles returns the file attachments of a pdf in the order they were written
+func readEmbeddedFiles(data []byte) ([]embeddedFile, error) {
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		return nil, err
+	}
+	var files []embeddedFile
+	for _, number := range sortedNumbers(objects) {
+		spec := objects[number]
+		if spec.name("Type") != "Filespec" {
+			continue
+		}
+		ref := pdfEmbeddedFileStream.FindStringSubmatch(spec.Dict)
+		if ref == nil {
+			continue
+		}
+		streamNumber, _ := strconv.Atoi(ref[1])
+		stream, ok := objects[streamNumber]
+		if !ok {
+			return nil, fmt.Errorf("embedded file stream %d is missing", streamNumber)
+		}
+		content, err := stream.decodedStream()
+		if err != nil {
+			return nil, fmt.Errorf("embedded file stream %d: %s", streamNumber, err)
+		}
+		name, ok := spec.stringEntry("UF")
+		if !ok || name == "" {
+			name, _ = spec.stringEntry("F")
+		}
+		description, _ := spec.stringEntry("Desc")
+		files = append(files, embeddedFile{Name: name, Description: description, Content: content})
+	}
+	return files, nil
+}
+
+// extractSource returns the letter embedded in pdfFile by EmbedSource
+func extractSource(pdfFile string) ([]byte, error) {
+	data, err := os.ReadFile(pdfFile)
+	if err != nil {
+		return nil, describeFileError(pdfFile, err)
+	}
+	if start := bytes.LastIndex(data, []byte("trailer")); start >= 0 && bytes.Contains(data[start:], []byte("/Encrypt")) {
+		return nil, errors.New(pdfFile + " is password protected, its letter source cannot be extracted")
+	}
+	files, err := readEmbeddedFiles(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", pdfFile, err)
+	}
+	for _, file := range files {
+		if file.Description == sourceDescription {
+			return file.Content, nil
+		}
+	}
+	return nil, errors.New(pdfFile + " contains no letter source, it was not rendered with EmbedSource")
+}
@@ -0,0 +1,73 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbedSourceAndAttachments(t *testing.T) {
+	dir := t.TempDir()
+	attachment := filepath.Join(dir, "invoice.csv")
+	if err := os.WriteFile(attachment, []byte("item;price\nletter;1.00\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	letter := "// config\n{\"EmbedSource\": true, \"Attachments\": [\"" + attachment + "\"]}\n// address\nName\n// subject\nSubject\n// body\nBody\n"
+	inputFile := filepath.Join(dir, "letter.left")
+	if err := os.WriteFile(inputFile, []byte(letter), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := render(inputFile, defaultConfig, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(outputFileName(inputFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := readEmbeddedFiles(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, len(files), 2, "embedded files")
+	AssertEquals(t, files[0].Name, "letter.left", "source name")
+	AssertEquals(t, files[1].Name, "invoice.csv", "attachment name")
+	AssertEquals(t, string(files[1].Content), "item;price\nletter;1.00\n", "attachment content")
+
+	source, err := extractSource(outputFileName(inputFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, string(source), letter, "extracted source")
+}
+
+func TestExtractWithoutSource(t *testing.T) {
+	inputFile, err := renderTestLetter(t, minimalLetter, "pdf", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(inputFile)
+	pdfFile := outputFileName(inputFile)
+	_, err = extractSource(pdfFile)
+	AssertEquals(t, err.Error(), pdfFile+" contains no letter source, it was not rendered with EmbedSource", "error")
+	_, err = extractSource(filepath.Join(dir, "missing.pdf"))
+	AssertEquals(t, err.Error(), filepath.Join(dir, "missing.pdf")+" not found", "missing pdf")
+}
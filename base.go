@@ -19,6 +19,16 @@
  */
 package main
 
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
 func MapStrings(input []string, mapper func(string) string) []string {
 	output := make([]string, len(input))
 	for i := 0; i < len(input); i++ {
@@ -35,3 +45,44 @@ func Contains[T comparable](array []T, element T) bool {
 	}
 	return false
 }
+
+// describeFileError turns the error of reading a file into a short message, e.g. "x.ttf not found"
+func describeFileError(path string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s not found", path)
+	}
+	return err
+}
+
+// writeFileAtomic writes data to a temporary file next to fileName and renames it to fileName, so that viewers
+// showing the file never see it half written. Like os.WriteFile, a new file gets perm less the umask and a replaced
+// file keeps its mode.
+func writeFileAtomic(fileName string, data []byte, perm fs.FileMode) error {
+	info, err := os.Stat(fileName)
+	replaced := err == nil && info.Mode().IsRegular()
+	// unlike os.CreateTemp, which always uses 0600, os.OpenFile applies the umask to perm
+	var file *os.File
+	for attempt := 0; attempt < 100; attempt++ {
+		name := filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+"."+strconv.FormatUint(uint64(rand.Uint32()), 10)+".tmp")
+		if file, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm); !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil && replaced {
+		err = file.Chmod(info.Mode().Perm())
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), fileName)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
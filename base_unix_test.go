@@ -0,0 +1,67 @@
+//go:build unix
+
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomicUmask(t *testing.T) {
+	defer syscall.Umask(syscall.Umask(077))
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "letter.pdf")
+	if err := writeFileAtomic(fileName, []byte("confidential"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, info.Mode().Perm(), os.FileMode(0600), "mode of a new file")
+
+	if err = os.Chmod(fileName, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeFileAtomic(fileName, []byte("replaced"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(fileName); err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, info.Mode().Perm(), os.FileMode(0640), "mode of a replaced file")
+
+	inputFile := filepath.Join(dir, "input.left")
+	if err = os.WriteFile(inputFile, []byte("// config\n{}\n// address\nYou\n// subject\nHi\n// body\nText\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = render(inputFile, defaultConfig, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(filepath.Join(dir, "input.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, info.Mode().Perm(), os.FileMode(0600), "mode of a rendered pdf")
+}
@@ -0,0 +1,331 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"unicode"
+)
+
+type bidiClass int
+
+const (
+	bidiNeutral bidiClass = iota
+	bidiLeftToRight
+	bidiRightToLeft
+	bidiArabicLetter
+	bidiEuropeanNumber
+	bidiArabicNumber
+)
+
+type arabicJoining int
+
+const (
+	joinNone arabicJoining = iota
+	joinRight
+	joinDual
+	joinCausing
+	joinTransparent
+)
+
+// arabicForm lists the presentation forms of an arabic letter: isolated, final, initial, medial.
+// Right joining letters only have an isolated and a final form.
+type arabicForm [4]rune
+
+const (
+	formIsolated = 0
+	formFinal    = 1
+	formInitial  = 2
+	formMedial   = 3
+)
+
+var arabicForms = map[rune]arabicForm{
+	'ء': {0xFE80},
+	'آ': {0xFE81, 0xFE82},
+	'أ': {0xFE83, 0xFE84},
+	'ؤ': {0xFE85, 0xFE86},
+	'إ': {0xFE87, 0xFE88},
+	'ئ': {0xFE89, 0xFE8A, 0xFE8B, 0xFE8C},
+	'ا': {0xFE8D, 0xFE8E},
+	'ب': {0xFE8F, 0xFE90, 0xFE91, 0xFE92},
+	'ة': {0xFE93, 0xFE94},
+	'ت': {0xFE95, 0xFE96, 0xFE97, 0xFE98},
+	'ث': {0xFE99, 0xFE9A, 0xFE9B, 0xFE9C},
+	'ج': {0xFE9D, 0xFE9E, 0xFE9F, 0xFEA0},
+	'ح': {0xFEA1, 0xFEA2, 0xFEA3, 0xFEA4},
+	'خ': {0xFEA5, 0xFEA6, 0xFEA7, 0xFEA8},
+	'د': {0xFEA9, 0xFEAA},
+	'ذ': {0xFEAB, 0xFEAC},
+	'ر': {0xFEAD, 0xFEAE},
+	'ز': {0xFEAF, 0xFEB0},
+	'س': {0xFEB1, 0xFEB2, 0xFEB3, 0xFEB4},
+	'ش': {0xFEB5, 0xFEB6, 0xFEB7, 0xFEB8},
+	'ص': {0xFEB9, 0xFEBA, 0xFEBB, 0xFEBC},
+	'ض': {0xFEBD, 0xFEBE, 0xFEBF, 0xFEC0},
+	'ط': {0xFEC1, 0xFEC2, 0xFEC3, 0xFEC4},
+	'ظ': {0xFEC5, 0xFEC6, 0xFEC7, 0xFEC8},
+	'ع': {0xFEC9, 0xFECA, 0xFECB, 0xFECC},
+	'غ': {0xFECD, 0xFECE, 0xFECF, 0xFED0},
+	'ف': {0xFED1, 0xFED2, 0xFED3, 0xFED4},
+	'ق': {0xFED5, 0xFED6, 0xFED7, 0xFED8},
+	'ك': {0xFED9, 0xFEDA, 0xFEDB, 0xFEDC},
+	'ل': {0xFEDD, 0xFEDE, 0xFEDF, 0xFEE0},
+	'م': {0xFEE1, 0xFEE2, 0xFEE3, 0xFEE4},
+	'ن': {0xFEE5, 0xFEE6, 0xFEE7, 0xFEE8},
+	'ه': {0xFEE9, 0xFEEA, 0xFEEB, 0xFEEC},
+	'و': {0xFEED, 0xFEEE},
+	'ى': {0xFEEF, 0xFEF0},
+	'ي': {0xFEF1, 0xFEF2, 0xFEF3, 0xFEF4},
+	'پ': {0xFB56, 0xFB57, 0xFB58, 0xFB59},
+	'چ': {0xFB7A, 0xFB7B, 0xFB7C, 0xFB7D},
+	'ژ': {0xFB8A, 0xFB8B},
+	'ک': {0xFB8E, 0xFB8F, 0xFB90, 0xFB91},
+	'گ': {0xFB92, 0xFB93, 0xFB94, 0xFB95},
+	'ی': {0xFBFC, 0xFBFD, 0xFBFE, 0xFBFF},
+}
+
+// lamAlefLigatures maps the alef variants to the isolated and final form of their ligature with a preceding lam
+var lamAlefLigatures = map[rune][2]rune{
+	'آ': {0xFEF5, 0xFEF6},
+	'أ': {0xFEF7, 0xFEF8},
+	'إ': {0xFEF9, 0xFEFA},
+	'ا': {0xFEFB, 0xFEFC},
+}
+
+var mirroredRunes = map[rune]rune{
+	'(': ')', ')': '(',
+	'[': ']', ']': '[',
+	'{': '}', '}': '{',
+	'<': '>', '>': '<',
+	'«': '»', '»': '«',
+	'‹': '›', '›': '‹',
+}
+
+func classify(r rune) bidiClass {
+	switch {
+	case r >= '0' && r <= '9':
+		return bidiEuropeanNumber
+	case r >= 0x0660 && r <= 0x0669, r >= 0x06F0 && r <= 0x06F9:
+		return bidiArabicNumber
+	case r >= 0x0590 && r <= 0x05FF, r >= 0xFB1D && r <= 0xFB4F:
+		return bidiRightToLeft
+	case r >= 0x0600 && r <= 0x07BF, r >= 0xFB50 && r <= 0xFDFF, r >= 0xFE70 && r <= 0xFEFF:
+		if unicode.Is(unicode.Mn, r) {
+			return bidiNeutral
+		}
+		return bidiArabicLetter
+	case unicode.IsLetter(r):
+		return bidiLeftToRight
+	default:
+		return bidiNeutral
+	}
+}
+
+func joiningType(r rune) arabicJoining {
+	if r == 0x0640 || r == 0x200D {
+		return joinCausing
+	}
+	if unicode.Is(unicode.Mn, r) {
+		return joinTransparent
+	}
+	forms, ok := arabicForms[r]
+	switch {
+	case !ok:
+		return joinNone
+	case forms[formInitial] != 0:
+		return joinDual
+	case forms[formFinal] != 0:
+		return joinRight
+	default:
+		return joinNone
+	}
+}
+
+// containsRightToLeft reports whether s contains any characters that are written from right to left
+func containsRightToLeft(s string) bool {
+	for _, r := range s {
+		if c := classify(r); c == bidiRightToLeft || c == bidiArabicLetter {
+			return true
+		}
+	}
+	return false
+}
+
+// shapeArabic replaces arabic letters with the presentation form matching their position in the word
+// and joins lam and alef into their mandatory ligature. s is expected in logical order.
+func shapeArabic(s string) string {
+	runes := []rune(s)
+	neighbour := func(i int, step int) arabicJoining {
+		for j := i + step; j >= 0 && j < len(runes); j += step {
+			if t := joiningType(runes[j]); t != joinTransparent {
+				return t
+			}
+		}
+		return joinNone
+	}
+	result := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		t := joiningType(r)
+		if t != joinDual && t != joinRight {
+			result = append(result, r)
+			continue
+		}
+		previous := neighbour(i, -1)
+		joinsPrevious := previous == joinDual || previous == joinCausing
+		if r == 'ل' && i+1 < len(runes) {
+			if ligature, ok := lamAlefLigatures[runes[i+1]]; ok {
+				if joinsPrevious {
+					result = append(result, ligature[formFinal])
+				} else {
+					result = append(result, ligature[formIsolated])
+				}
+				i++
+				continue
+			}
+		}
+		next := neighbour(i, 1)
+		joinsNext := t == joinDual && (next == joinDual || next == joinRight || next == joinCausing)
+		forms := arabicForms[r]
+		switch {
+		case joinsPrevious && joinsNext:
+			result = append(result, forms[formMedial])
+		case joinsPrevious:
+			result = append(result, forms[formFinal])
+		case joinsNext:
+			result = append(result, forms[formInitial])
+		default:
+			result = append(result, forms[formIsolated])
+		}
+	}
+	return string(result)
+}
+
+// reorderBidi converts a single line from logical to visual order following a simplified version of the unicode
+// bidirectional algorithm: no explicit embeddings, numbers and neutrals are resolved by their surrounding
+// strong characters.
+func reorderBidi(s string, rightToLeft bool) string {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return s
+	}
+	baseLevel := 0
+	if rightToLeft {
+		baseLevel = 1
+	}
+	classes := make([]bidiClass, len(runes))
+	for i, r := range runes {
+		classes[i] = classify(r)
+	}
+	leftToRightLevel := baseLevel + baseLevel%2
+	rightToLeftLevel := 1
+	// european numbers following arabic letters behave like arabic numbers, those following latin letters like latin
+	lastStrong := bidiLeftToRight
+	if rightToLeft {
+		lastStrong = bidiRightToLeft
+	}
+	for i, c := range classes {
+		switch c {
+		case bidiLeftToRight, bidiRightToLeft, bidiArabicLetter:
+			lastStrong = c
+		case bidiEuropeanNumber:
+			if lastStrong == bidiArabicLetter {
+				classes[i] = bidiArabicNumber
+			} else if lastStrong == bidiLeftToRight {
+				classes[i] = bidiLeftToRight
+			}
+		}
+	}
+	// level of the strong direction a neutral character sees, numbers count as right to left
+	strongLevel := func(c bidiClass) int {
+		switch c {
+		case bidiLeftToRight:
+			return leftToRightLevel
+		case bidiRightToLeft, bidiArabicLetter, bidiEuropeanNumber, bidiArabicNumber:
+			return rightToLeftLevel
+		}
+		return -1
+	}
+	levels := make([]int, len(runes))
+	for i, c := range classes {
+		switch c {
+		case bidiLeftToRight:
+			levels[i] = leftToRightLevel
+		case bidiRightToLeft, bidiArabicLetter:
+			levels[i] = rightToLeftLevel
+		case bidiEuropeanNumber, bidiArabicNumber:
+			levels[i] = 2
+		default:
+			before, after := baseLevel, baseLevel
+			for j := i - 1; j >= 0; j-- {
+				if level := strongLevel(classes[j]); level >= 0 {
+					before = level
+					break
+				}
+			}
+			for j := i + 1; j < len(classes); j++ {
+				if level := strongLevel(classes[j]); level >= 0 {
+					after = level
+					break
+				}
+			}
+			if before == after {
+				levels[i] = before
+			} else {
+				levels[i] = baseLevel
+			}
+		}
+	}
+	// trailing whitespace always takes the paragraph direction
+	for i := len(runes) - 1; i >= 0 && unicode.IsSpace(runes[i]); i-- {
+		levels[i] = baseLevel
+	}
+	maxLevel := 0
+	for _, level := range levels {
+		if level > maxLevel {
+			maxLevel = level
+		}
+	}
+	for i, r := range runes {
+		if levels[i]%2 == 1 {
+			if mirrored, ok := mirroredRunes[r]; ok {
+				runes[i] = mirrored
+			}
+		}
+	}
+	// reverse every sequence of characters at or above each level, from the highest to the lowest odd level
+	for level := maxLevel; level >= 1; level-- {
+		for start := 0; start < len(runes); {
+			if levels[start] < level {
+				start++
+				continue
+			}
+			end := start
+			for end < len(runes) && levels[end] >= level {
+				end++
+			}
+			for i, j := start, end-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+				levels[i], levels[j] = levels[j], levels[i]
+			}
+			start = end
+		}
+	}
+	return string(runes)
+}
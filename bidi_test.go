@@ -0,0 +1,63 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"testing"
+)
+
+func TestReorderBidi(t *testing.T) {
+	cases := []struct {
+		logical     string
+		rightToLeft bool
+		want        string
+	}{
+		{"Dear sir or madam,", false, "Dear sir or madam,"},
+		{"Mr שלום Cohen", false, "Mr םולש Cohen"},
+		{"שלום 123 עולם", false, "םלוע 123 םולש"},
+		{"שלום Cohen", true, "Cohen םולש"},
+		{"(שלום)", true, "(םולש)"},
+		{"רחוב הרצל 42", true, "42 לצרה בוחר"},
+		{"מחיר: 100 ש\"ח.", true, ".ח\"ש 100 :ריחמ"},
+	}
+	for _, c := range cases {
+		AssertEquals(t, reorderBidi(c.logical, c.rightToLeft), c.want, c.logical)
+	}
+}
+
+func TestShapeArabic(t *testing.T) {
+	cases := []struct {
+		logical string
+		want    string
+	}{
+		// seen (initial) + lam alef (final ligature) + meem (isolated, alef does not join to the left)
+		{"سلام", "ﺳﻼﻡ"},
+		// beh (initial) + teh (medial) + beh (final)
+		{"ببب", "ﺑﺒﺐ"},
+		// the transparent fatha does not break the joining
+		{"بَب", "ﺑَﺐ"},
+		// dal only joins to the right, so the following beh is isolated
+		{"دب", "ﺩﺏ"},
+		{"abc", "abc"},
+	}
+	for _, c := range cases {
+		AssertEquals(t, shapeArabic(c.logical), c.want, c.logical)
+	}
+}
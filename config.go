@@ -20,24 +20,52 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"path"
-	"time"
+	"path/filepath"
+	"reflect"
+	"strings"
 )
 
+const projectConfigFileName = ".left.json"
+
 type FontImport struct {
-	Name             string
-	Directory        string
-	FontFileName     string
-	FontFileNameBold string
+	Name                   string
+	Directory              string
+	FontFileName           string
+	FontFileNameBold       string
+	FontFileNameItalic     string
+	FontFileNameBoldItalic string
+}
+
+// Protection password protects the pdf. The passwords are read from files or from the environment variables
+// LEFT_USER_PASSWORD and LEFT_OWNER_PASSWORD, which take precedence, so that they never end up in a letter.
+type Protection struct {
+	// UserPasswordFile holds the password needed to open the pdf
+	UserPasswordFile string
+	// OwnerPasswordFile holds the password that lifts all restrictions
+	OwnerPasswordFile string
+	// Permissions lists what the user password allows: "print", "copy" and "modify"
+	Permissions []string
+}
+
+// Metadata is the document information shown by pdf viewers and document management systems. Empty fields are
+// derived from the letter: the title from the subject line and the author from SenderName.
+type Metadata struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
 }
 
 type Config struct {
-	FontName          string
-	FontImport        *FontImport
+	FontName   string
+	FontImport *FontImport
+	// FontFallback lists the fonts to try, in order, for characters that FontName cannot render
+	FontFallback      []string
 	FontSize          float64
 	FontSizeSender    float64
 	FontSizeAddress   float64
@@ -49,13 +77,48 @@ type Config struct {
 	DateY             float64
 	Margins           float64
 	DatePrefix        string
-	Date              string
-	Sender            []string
-	// Pointers so that we can unset a field that was specified in a more global config
+	// Date is either a keyword such as "today", an ISO date or a literal text. See resolveDate.
+	Date string
+	// DateFormat is a go time layout, e.g. "02.01.2006" or "2. January 2006"
+	DateFormat string
+	Locale     string
+	// Direction is either "ltr" or "rtl", the latter mirroring the layout for right to left languages
+	Direction string
+	// PDFA is the PDF/A conformance level to produce, "1b" or "2b", or empty for a plain pdf
+	PDFA string
+	// Metadata overrides the document information that is otherwise derived from the letter
+	Metadata Metadata
+	// EmbedSource embeds the letter itself in the pdf, so that it can be recovered with -extract
+	EmbedSource bool
+	// Attachments are files embedded in the pdf, e.g. an invoice
+	Attachments []string
+	// Enclosures are pdf files and images appended as pages after the letter and listed below the signature
+	Enclosures []string
+	Protection *Protection
+	// Reproducible makes rendering the same letter yield the same file, see creationTime
+	Reproducible bool
+	// TextWidth is the number of characters per line of the txt and md formats
+	TextWidth int
+	Sender    []string
+	// Pointers because these fields have no built-in default
 	SenderName *string
 	Signature  *string
 }
 
+// configError is an error caused by the value of a config field
+type configError struct {
+	Field string
+	Err   error
+}
+
+func (e *configError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *configError) Unwrap() error {
+	return e.Err
+}
+
 func (c Config) GetSenderNameOrEmpty() string {
 	if c.SenderName == nil {
 		return ""
@@ -64,6 +127,26 @@ func (c Config) GetSenderNameOrEmpty() string {
 	}
 }
 
+// documentTitle returns the title of the rendered document, the subject line unless Metadata overrides it
+func (c Config) documentTitle(subject string) string {
+	if c.Metadata.Title != "" {
+		return c.Metadata.Title
+	}
+	return strings.TrimSpace(subject)
+}
+
+// documentAuthor returns the author of the rendered document, SenderName unless Metadata overrides it
+func (c Config) documentAuthor() string {
+	if c.Metadata.Author != "" {
+		return c.Metadata.Author
+	}
+	return c.GetSenderNameOrEmpty()
+}
+
+func (c Config) isRightToLeft() bool {
+	return strings.EqualFold(c.Direction, "rtl")
+}
+
 func (c Config) GetSignatureOrEmpty() string {
 	if c.Signature == nil {
 		return ""
@@ -74,6 +157,7 @@ func (c Config) GetSignatureOrEmpty() string {
 
 var defaultConfig = Config{
 	FontName:          "dejavusanscondensed",
+	FontFallback:      []string{},
 	FontSize:          12,
 	FontSizeSender:    7,
 	FontSizeAddress:   10,
@@ -84,7 +168,12 @@ var defaultConfig = Config{
 	AddressSectionW:   70,
 	DateY:             100,
 	Margins:           25,
-	Date:              time.Now().Format("02.01.2006"),
+	Date:              "today",
+	DateFormat:        "02.01.2006",
+	Direction:         "ltr",
+	Attachments:       []string{},
+	Enclosures:        []string{},
+	TextWidth:         72,
 	Sender:            []string{},
 }
 
@@ -106,7 +195,7 @@ func loadConfigFromFile(configPath string, dest *Config) error {
 		}
 		return nil
 	}
-	err = json.Unmarshal(data, &dest)
+	err = mergeConfigJson(data, dest)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Could not parse file %s as json: %s\n", configPath, err))
 	} else {
@@ -114,24 +203,114 @@ func loadConfigFromFile(configPath string, dest *Config) error {
 	}
 }
 
-func GetConfigFilePaths(goos string, customConfigFilePath string) []string {
+// mergeConfigJson applies the json encoded config layer data on top of dest.
+// Fields missing in data are left untouched, fields that are explicitly set to null are reset to their built-in
+// default and all other fields override the value in dest, even if they are empty. The same goes for the fields of
+// FontImport, Metadata and Protection.
+func mergeConfigJson(data []byte, dest *Config) error {
+	if err := resetNullFields(data, reflect.ValueOf(dest).Elem(), reflect.ValueOf(defaultConfig)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// resetNullFields resets the fields of the struct dest that the json object data sets to null to their value in
+// defaults, descending into nested structs. Nested structs behind pointers are copied first, as other configs may
+// share them.
+func resetNullFields(data []byte, dest reflect.Value, defaults reflect.Value) error {
+	var layer map[string]json.RawMessage
+	if err := json.Unmarshal(data, &layer); err != nil {
+		return err
+	}
+	for key, value := range layer {
+		value = bytes.TrimSpace(value)
+		// encoding/json matches keys case-insensitively, so do we
+		for i := 0; i < dest.NumField(); i++ {
+			if !strings.EqualFold(dest.Type().Field(i).Name, key) {
+				continue
+			}
+			field, fieldDefault := dest.Field(i), defaults.Field(i)
+			isObject := len(value) > 0 && value[0] == '{'
+			switch {
+			case string(value) == "null":
+				field.Set(fieldDefault)
+			case isObject && field.Kind() == reflect.Struct:
+				if err := resetNullFields(value, field, fieldDefault); err != nil {
+					return err
+				}
+			case isObject && field.Kind() == reflect.Pointer && field.Type().Elem().Kind() == reflect.Struct && !field.IsNil():
+				copied := reflect.New(field.Type().Elem())
+				copied.Elem().Set(field.Elem())
+				field.Set(copied)
+				nestedDefault := reflect.Zero(field.Type().Elem())
+				if !fieldDefault.IsNil() {
+					nestedDefault = fieldDefault.Elem()
+				}
+				if err := resetNullFields(value, copied.Elem(), nestedDefault); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetConfigFilePaths returns the config files to read, ordered from the most global to the most specific one.
+// letterFile is used to look up project-local config files. If it is empty, the lookup starts in the working directory.
+func GetConfigFilePaths(goos string, customConfigFilePath string, letterFile string) []string {
 	var paths []string
 	if goos == "linux" {
 		paths = append(paths, "/etc/left/defaults.json")
 	}
+	// XDG_CONFIG_DIRS is ordered by preference, so the most important directory has to be read last
+	xdgConfigDirs := filepath.SplitList(os.Getenv("XDG_CONFIG_DIRS"))
+	for i := len(xdgConfigDirs) - 1; i >= 0; i-- {
+		if xdgConfigDirs[i] != "" {
+			paths = append(paths, filepath.Join(xdgConfigDirs[i], "left", "defaults.json"))
+		}
+	}
 	userDir, err := os.UserConfigDir()
 	if err != nil {
 		//goland:noinspection GoUnhandledErrorResult
 		fmt.Fprintf(os.Stderr, "Could not read user config: %s\n", err)
 	} else {
-		paths = append(paths, path.Join(userDir, "left", "defaults.json"))
+		paths = append(paths, filepath.Join(userDir, "left", "defaults.json"))
 	}
+	for _, configPath := range filepath.SplitList(os.Getenv("LEFT_CONFIG_PATH")) {
+		if configPath != "" {
+			paths = append(paths, configPath)
+		}
+	}
+	paths = append(paths, findProjectConfigFiles(letterFile)...)
 	if customConfigFilePath != "" {
 		paths = append(paths, customConfigFilePath)
+	} else if envConfigFilePath := os.Getenv("LEFT_CONFIG"); envConfigFilePath != "" {
+		paths = append(paths, envConfigFilePath)
 	}
 	return paths
 }
 
+// findProjectConfigFiles walks up from the directory of letterFile (the way git finds .git) and returns all
+// project-local config files found on the way, the outermost one first.
+func findProjectConfigFiles(letterFile string) []string {
+	dir, err := filepath.Abs(filepath.Dir(letterFile))
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for {
+		candidate := filepath.Join(dir, projectConfigFileName)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			found = append([]string{candidate}, found...)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return found
+		}
+		dir = parent
+	}
+}
+
 func loadDefaultConfig(pathsToRead []string) (Config, error) {
 	result := defaultConfig
 	var err error
@@ -150,21 +329,22 @@ func createEmptyLetter(config Config) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	result := "You can put random notes here. Anything before the first section will be ignored.\n"
-	result += "Config sections are started with a line that begins with //\n"
+	locale := resolveLocale(config.Locale)
+	result := localize(locale, "letter.notes") + "\n"
+	result += localize(locale, "letter.sections") + "\n"
 	result += "// config\n"
 	result += conf + "\n"
 	result += "// address\n"
-	result += "Name\n"
-	result += "Street\n"
-	result += "City\n"
+	result += localize(locale, "letter.name") + "\n"
+	result += localize(locale, "letter.street") + "\n"
+	result += localize(locale, "letter.city") + "\n"
 	result += "// subject\n"
-	result += "Add your subject here. This section must not have more than one line.\n"
+	result += localize(locale, "letter.subject") + "\n"
 	result += "// body\n"
-	result += "Dear sir or madam,\n"
+	result += localize(locale, "letter.salutation") + "\n"
 	result += "\n"
 	result += "\n"
 	result += "\n"
-	result += "Kind regards,\n"
+	result += localize(locale, "letter.closing") + "\n"
 	return result, nil
 }
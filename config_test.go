@@ -20,6 +20,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -86,6 +88,84 @@ func TestReadConfigWithoutBoldFromFile(t *testing.T) {
 	AssertEquals(t, read.FontImport.FontFileNameBold, "", "FontImport.FontFileNameBold")
 }
 
+func TestNullResetsFieldsToDefaults(t *testing.T) {
+	read, err := loadDefaultConfig([]string{
+		"./test/config/valid_config_full.json",
+		"./test/config/valid_config_nulls.json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fontImport *FontImport = nil
+	var nilStringPtr *string
+	AssertEquals(t, read.FontName, defaultConfig.FontName, "FontName")
+	AssertEquals(t, read.FontImport, fontImport, "FontImport")
+	AssertEquals(t, read.FontSize, defaultConfig.FontSize, "FontSize")
+	AssertEquals(t, read.FontSizeSender, float64(43), "FontSizeSender")
+	AssertEquals(t, read.Margins, float64(0), "Margins")
+	AssertEquals(t, read.DatePrefix, "", "DatePrefix")
+	AssertEquals(t, read.Date, defaultConfig.Date, "Date")
+	AssertStringSliceEquals(t, read.Sender, []string{}, "Sender")
+	AssertEquals(t, read.SenderName, nilStringPtr, "SenderName")
+	AssertEquals(t, *read.Signature, "", "Signature")
+}
+
+func TestNullResetsNestedFields(t *testing.T) {
+	base := defaultConfig
+	if err := mergeConfigJson([]byte(`{"FontImport": {"Name": "Noto", "FontFileName": "Noto.ttf", "FontFileNameBold": "NotoBold.ttf"},
+		"Metadata": {"Title": "Invoice", "Author": "Me"}, "Protection": {"UserPasswordFile": "user.txt", "Permissions": ["print"]}}`), &base); err != nil {
+		t.Fatal(err)
+	}
+	read := base
+	if err := mergeConfigJson([]byte(`{"FontImport": {"FontFileNameBold": null}, "metadata": {"Title": null},
+		"Protection": {"Permissions": null}}`), &read); err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, read.FontImport.Name, "Noto", "FontImport.Name")
+	AssertEquals(t, read.FontImport.FontFileName, "Noto.ttf", "FontImport.FontFileName")
+	AssertEquals(t, read.FontImport.FontFileNameBold, "", "FontImport.FontFileNameBold")
+	AssertEquals(t, read.Metadata.Title, "", "Metadata.Title")
+	AssertEquals(t, read.Metadata.Author, "Me", "Metadata.Author")
+	AssertEquals(t, read.Protection.UserPasswordFile, "user.txt", "Protection.UserPasswordFile")
+	AssertStringSliceEquals(t, read.Protection.Permissions, nil, "Protection.Permissions")
+	// the config the layer was applied to is left alone
+	AssertEquals(t, base.FontImport.FontFileNameBold, "NotoBold.ttf", "FontImport.FontFileNameBold of the lower layer")
+	AssertStringSliceEquals(t, base.Protection.Permissions, []string{"print"}, "Protection.Permissions of the lower layer")
+}
+
+func TestConfigFilePathsFromEnvironment(t *testing.T) {
+	projectDir := t.TempDir()
+	letterDir := filepath.Join(projectDir, "letters", "2023")
+	if err := os.MkdirAll(letterDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{projectDir, letterDir} {
+		if err := os.WriteFile(filepath.Join(dir, ".left.json"), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("XDG_CONFIG_DIRS", "/etc/xdg-preferred:/etc/xdg")
+	t.Setenv("XDG_CONFIG_HOME", "/home/user/.config")
+	t.Setenv("LEFT_CONFIG_PATH", "/ci/first.json:/ci/second.json")
+	t.Setenv("LEFT_CONFIG", "/ci/left.json")
+
+	paths := GetConfigFilePaths("linux", "", filepath.Join(letterDir, "letter.left"))
+	AssertStringSliceEquals(t, paths, []string{
+		"/etc/left/defaults.json",
+		"/etc/xdg/left/defaults.json",
+		"/etc/xdg-preferred/left/defaults.json",
+		"/home/user/.config/left/defaults.json",
+		"/ci/first.json",
+		"/ci/second.json",
+		filepath.Join(projectDir, ".left.json"),
+		filepath.Join(letterDir, ".left.json"),
+		"/ci/left.json",
+	}, "paths")
+
+	paths = GetConfigFilePaths("linux", "/custom.json", filepath.Join(letterDir, "letter.left"))
+	AssertEquals(t, paths[len(paths)-1], "/custom.json", "custom config overrides LEFT_CONFIG")
+}
+
 func AssertEquals(t *testing.T, got any, want any, description string) {
 	if got != want {
 		t.Errorf("%s: got %q, wanted %q", description, got, want)
@@ -0,0 +1,159 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const isoDateLayout = "2006-01-02"
+
+type dateNames struct {
+	months      [12]string
+	monthsShort [12]string
+	days        [7]string
+	daysShort   [7]string
+}
+
+var localizedDateNames = map[string]dateNames{
+	"en": {
+		months:      [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		monthsShort: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		days:        [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		daysShort:   [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+	},
+	"de": {
+		months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		monthsShort: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sep.", "Okt.", "Nov.", "Dez."},
+		days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		daysShort:   [7]string{"So.", "Mo.", "Di.", "Mi.", "Do.", "Fr.", "Sa."},
+	},
+	"fr": {
+		months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		monthsShort: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		days:        [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		daysShort:   [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+}
+
+// normalizeLocale reduces locale identifiers such as "de_DE.UTF-8" or "fr-FR" to their language part.
+func normalizeLocale(locale string) string {
+	language, _, _ := strings.Cut(locale, ".")
+	language, _, _ = strings.Cut(language, "_")
+	language, _, _ = strings.Cut(language, "-")
+	return strings.ToLower(language)
+}
+
+// resolveDate computes the date to print on the letter.
+// Date may be one of the keywords "today", "yesterday" and "tomorrow" or an ISO date (2006-01-02), which are both
+// formatted according to DateFormat and Locale. Any other value is printed as is.
+func (c Config) resolveDate(now time.Time) string {
+	date, ok := c.dateTime(now)
+	if !ok {
+		return c.Date
+	}
+	layout := c.DateFormat
+	if layout == "" {
+		layout = defaultConfig.DateFormat
+	}
+	return formatDate(date, layout, c.Locale)
+}
+
+// dateTime returns the point in time Date refers to. ISO dates refer to midnight in the local time zone. The result
+// is false if Date is a literal text.
+func (c Config) dateTime(now time.Time) (time.Time, bool) {
+	switch strings.ToLower(strings.TrimSpace(c.Date)) {
+	case "today":
+		return now, true
+	case "yesterday":
+		return now.AddDate(0, 0, -1), true
+	case "tomorrow":
+		return now.AddDate(0, 0, 1), true
+	}
+	parsed, err := time.ParseInLocation(isoDateLayout, strings.TrimSpace(c.Date), now.Location())
+	return parsed, err == nil
+}
+
+// formatDate works like time.Format, but prints month and weekday names in the language of locale.
+// See resolveLocale for how the locale is chosen.
+func formatDate(date time.Time, layout string, locale string) string {
+	names := localizedDateNames[resolveLocale(locale)]
+	var result strings.Builder
+	segmentStart := 0
+	for i := 0; i < len(layout); {
+		var name string
+		var length int
+		switch {
+		case strings.HasPrefix(layout[i:], "January"):
+			name, length = names.months[date.Month()-1], len("January")
+		case strings.HasPrefix(layout[i:], "Jan"):
+			name, length = names.monthsShort[date.Month()-1], len("Jan")
+		case strings.HasPrefix(layout[i:], "Monday"):
+			name, length = names.days[date.Weekday()], len("Monday")
+		case strings.HasPrefix(layout[i:], "Mon"):
+			name, length = names.daysShort[date.Weekday()], len("Mon")
+		default:
+			i++
+			continue
+		}
+		result.WriteString(date.Format(layout[segmentStart:i]))
+		result.WriteString(name)
+		i += length
+		segmentStart = i
+	}
+	result.WriteString(date.Format(layout[segmentStart:]))
+	return result.String()
+}
+
+// sourceDateEpochVariable names the environment variable that fixes the current time for reproducible output, see
+// https://reproducible-builds.org/specs/source-date-epoch/
+const sourceDateEpochVariable = "SOURCE_DATE_EPOCH"
+
+// currentTime returns the time given by SOURCE_DATE_EPOCH in UTC if it is set, otherwise the current time. The
+// result is true if the time comes from SOURCE_DATE_EPOCH.
+func currentTime() (time.Time, bool, error) {
+	value, ok := os.LookupEnv(sourceDateEpochVariable)
+	if !ok || value == "" {
+		return time.Now(), false, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%s: %q is not a number of seconds since 1970", sourceDateEpochVariable, value)
+	}
+	return time.Unix(seconds, 0).UTC(), true, nil
+}
+
+// creationTime returns the time stamped into the pdf: the point in time Date refers to, or now if Date is a literal
+// text. For reproducible output a time read from the clock is reduced to midnight UTC of its day, so that rendering
+// a letter again on the same day yields the same file.
+func (c Config) creationTime(now time.Time, fixed bool, reproducible bool) time.Time {
+	created, ok := c.dateTime(now)
+	if !ok {
+		created = now
+	}
+	if reproducible && !fixed {
+		created = time.Date(created.Year(), created.Month(), created.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return created
+}
@@ -0,0 +1,75 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveDate(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		date       string
+		dateFormat string
+		locale     string
+		want       string
+	}{
+		{"today", "02.01.2006", "", "17.10.2026"},
+		{"Yesterday", "02.01.2006", "", "16.10.2026"},
+		{"tomorrow", "", "", "18.10.2026"},
+		{"2023-06-01", "02.01.2006", "", "01.06.2023"},
+		{"today", "2. January 2006", "de_DE.UTF-8", "17. Oktober 2026"},
+		{"today", "January 2, 2006", "en", "October 17, 2026"},
+		{"today", "2 January 2006", "fr-FR", "17 octobre 2026"},
+		{"2026-03-02", "Monday, 2. January 2006", "de", "Montag, 2. März 2026"},
+		{"2026-03-02", "Mon 2 Jan 2006", "unknown", "Mon 2 Mar 2026"},
+		{"01.06.2023", "2 January 2006", "fr", "01.06.2023"},
+		{"", "02.01.2006", "", ""},
+	}
+	for _, c := range cases {
+		config := Config{Date: c.date, DateFormat: c.dateFormat, Locale: c.locale}
+		AssertEquals(t, config.resolveDate(now), c.want, c.date+" formatted as "+c.dateFormat)
+	}
+}
+
+func TestCreationTime(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 12, 34, 56, 0, time.FixedZone("CEST", 2*60*60))
+	midnight := time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC)
+	AssertEquals(t, Config{Date: "today"}.creationTime(now, false, false), now, "current time")
+	AssertEquals(t, Config{Date: "today"}.creationTime(now, false, true), midnight, "reproducible current time")
+	AssertEquals(t, Config{Date: "in October"}.creationTime(now, false, true), midnight, "reproducible literal date")
+	AssertEquals(t, Config{Date: "2023-06-01"}.creationTime(now, false, true), time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC), "reproducible ISO date")
+	AssertEquals(t, Config{Date: "today"}.creationTime(now, true, true), now, "fixed time")
+}
+
+func TestCurrentTime(t *testing.T) {
+	t.Setenv(sourceDateEpochVariable, "1700000000")
+	now, fixed, err := currentTime()
+	AssertEquals(t, err, nil, "no error")
+	AssertEquals(t, fixed, true, "fixed")
+	AssertEquals(t, now, time.Date(2023, time.November, 14, 22, 13, 20, 0, time.UTC), "time")
+	t.Setenv(sourceDateEpochVariable, "yesterday")
+	_, _, err = currentTime()
+	AssertEquals(t, err.Error(), `SOURCE_DATE_EPOCH: "yesterday" is not a number of seconds since 1970`, "error")
+	t.Setenv(sourceDateEpochVariable, "")
+	_, fixed, _ = currentTime()
+	AssertEquals(t, fixed, false, "empty")
+}
@@ -0,0 +1,327 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	docxPageWidth  = 11906 // A4 in twips
+	docxPageHeight = 16838
+	docxNamespaces = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" ` +
+		`xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing" ` +
+		`xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" ` +
+		`xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture"`
+)
+
+// docxPart is a file in the zip package of a docx document
+type docxPart struct {
+	name    string
+	content []byte
+}
+
+// renderDocx writes the letter as an editable Word document. The address section and the date are frames anchored to
+// the page, so that they stay where the config puts them while the text flows between the page margins.
+func renderDocx(inputFile string, defaultConfig Config, options Options) ([]Warning, error) {
+	content, err := parseLetter(inputFile, defaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	config := content.config
+	now, fixedNow, err := currentTime()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := loadSignature(config)
+	if err != nil {
+		return nil, err
+	}
+	created := config.creationTime(now, fixedNow, options.Reproducible || config.Reproducible || fixedNow)
+	data, err := docxPackage(docxParts(content, signature, now, created), created)
+	if err != nil {
+		return nil, err
+	}
+	return nil, writeFileAtomic(formatFileName(inputFile, "docx"), data, 0644)
+}
+
+// docxPackage zips the parts in their order, all of them modified at the given time
+func docxPackage(parts []docxPart, modified time.Time) ([]byte, error) {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, part := range parts {
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: part.name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = writer.Write(part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// docxParts returns the parts of the document, [Content_Types].xml first as Word expects it
+func docxParts(content letter, signature *signatureImage, now time.Time, created time.Time) []docxPart {
+	contentTypes := `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Default Extension="jpg" ContentType="image/jpeg"/>` +
+		`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+		`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+		`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+		`<Override PartName="/docProps/app.xml" ContentType="application/vnd.openxmlformats-officedocument.extended-properties+xml"/>` +
+		`</Types>`
+	packageRelationships := `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>` +
+		`<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/extended-properties" Target="docProps/app.xml"/>` +
+		`</Relationships>`
+	documentRelationships := `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rIdStyles" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`
+	if signature != nil {
+		documentRelationships += `<Relationship Id="rIdSignature" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/signature.jpg"/>`
+	}
+	documentRelationships += `</Relationships>`
+	app := `<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties">` +
+		`<Application>` + xmlText("left "+strings.TrimSpace(Version())) + `</Application></Properties>`
+
+	parts := []docxPart{
+		{"[Content_Types].xml", xmlDocument(contentTypes)},
+		{"_rels/.rels", xmlDocument(packageRelationships)},
+		{"docProps/core.xml", xmlDocument(docxCoreProperties(content, created))},
+		{"docProps/app.xml", xmlDocument(app)},
+		{"word/_rels/document.xml.rels", xmlDocument(documentRelationships)},
+		{"word/styles.xml", xmlDocument(docxStyles(content.config))},
+		{"word/document.xml", xmlDocument(docxDocument(content, signature, now))},
+	}
+	if signature != nil {
+		parts = append(parts, docxPart{"word/media/signature.jpg", signature.Data})
+	}
+	return parts
+}
+
+// docxCoreProperties returns the document information, the same that is written into the pdf
+func docxCoreProperties(content letter, created time.Time) string {
+	config := content.config
+	var sb strings.Builder
+	sb.WriteString(`<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" ` +
+		`xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" ` +
+		`xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`)
+	element := func(name string, value string) {
+		if value != "" {
+			sb.WriteString("<" + name + ">" + xmlText(value) + "</" + name + ">")
+		}
+	}
+	element("dc:title", config.documentTitle(content.subject))
+	element("dc:creator", config.documentAuthor())
+	element("dc:subject", config.Metadata.Subject)
+	element("cp:keywords", config.Metadata.Keywords)
+	timestamp := created.UTC().Format("2006-01-02T15:04:05Z")
+	sb.WriteString(`<dcterms:created xsi:type="dcterms:W3CDTF">` + timestamp + `</dcterms:created>`)
+	sb.WriteString(`<dcterms:modified xsi:type="dcterms:W3CDTF">` + timestamp + `</dcterms:modified>`)
+	sb.WriteString(`</cp:coreProperties>`)
+	return sb.String()
+}
+
+// docxStyles returns the default font, size, language and line spacing of all paragraphs
+func docxStyles(config Config) string {
+	font := xmlAttribute(fontFamilyName(config.FontName))
+	locale := resolveLocale(config.Locale)
+	return `<w:styles ` + docxNamespaces + `><w:docDefaults><w:rPrDefault><w:rPr>` +
+		`<w:rFonts w:ascii=` + font + ` w:hAnsi=` + font + ` w:eastAsia=` + font + ` w:cs=` + font + `/>` +
+		`<w:sz w:val="` + halfPoints(config.FontSize) + `"/><w:szCs w:val="` + halfPoints(config.FontSize) + `"/>` +
+		`<w:lang w:val="` + locale + `" w:bidi="` + locale + `"/>` +
+		`</w:rPr></w:rPrDefault><w:pPrDefault><w:pPr>` +
+		`<w:spacing w:before="0" w:after="0" w:line="` + twips(config.LineHeight) + `" w:lineRule="exact"/>` +
+		`</w:pPr></w:pPrDefault></w:docDefaults></w:styles>`
+}
+
+// docxParagraph is a paragraph with its properties in the order the schema demands them
+type docxParagraph struct {
+	frame         string
+	borderBottom  bool
+	spacingBefore string
+	line          string
+	lineRule      string
+	align         string
+	bold          bool
+	size          float64
+	text          string
+	drawing       string
+}
+
+// docxDocument returns the body of the document: the address frame, the date frame and the text of the letter
+func docxDocument(content letter, signature *signatureImage, now time.Time) string {
+	config := content.config
+	rtl := config.isRightToLeft()
+	var sb strings.Builder
+	paragraph := func(p docxParagraph) {
+		var properties strings.Builder
+		properties.WriteString(p.frame)
+		if p.borderBottom {
+			properties.WriteString(`<w:pBdr><w:bottom w:val="single" w:sz="4" w:space="1" w:color="auto"/></w:pBdr>`)
+		}
+		if rtl {
+			properties.WriteString("<w:bidi/>")
+		}
+		if p.spacingBefore != "" || p.line != "" {
+			properties.WriteString("<w:spacing")
+			if p.spacingBefore != "" {
+				properties.WriteString(` w:before="` + p.spacingBefore + `"`)
+			}
+			if p.line != "" {
+				properties.WriteString(` w:line="` + p.line + `" w:lineRule="` + p.lineRule + `"`)
+			}
+			properties.WriteString("/>")
+		}
+		if p.align != "" {
+			properties.WriteString(`<w:jc w:val="` + p.align + `"/>`)
+		}
+		sb.WriteString("<w:p>")
+		if properties.Len() > 0 {
+			sb.WriteString("<w:pPr>" + properties.String() + "</w:pPr>")
+		}
+		if p.text != "" || p.drawing != "" {
+			var runProperties strings.Builder
+			if p.bold {
+				runProperties.WriteString("<w:b/><w:bCs/>")
+			}
+			if p.size > 0 {
+				runProperties.WriteString(`<w:sz w:val="` + halfPoints(p.size) + `"/><w:szCs w:val="` + halfPoints(p.size) + `"/>`)
+			}
+			if rtl {
+				runProperties.WriteString("<w:rtl/>")
+			}
+			sb.WriteString("<w:r>")
+			if runProperties.Len() > 0 {
+				sb.WriteString("<w:rPr>" + runProperties.String() + "</w:rPr>")
+			}
+			if p.drawing != "" {
+				sb.WriteString(p.drawing)
+			} else {
+				sb.WriteString(`<w:t xml:space="preserve">` + xmlText(p.text) + "</w:t>")
+			}
+			sb.WriteString("</w:r>")
+		}
+		sb.WriteString("</w:p>")
+	}
+
+	sb.WriteString(`<w:document ` + docxNamespaces + `><w:body>`)
+
+	// paragraphs with the same frame properties share one frame, the address section is mirrored for right to left
+	addressX := config.AddressSectionX
+	if rtl {
+		addressX = 210 - config.AddressSectionX - config.AddressSectionW
+	}
+	addressFrame := `<w:framePr w:w="` + twips(config.AddressSectionW) + `" w:wrap="around" w:hAnchor="page" w:vAnchor="page" ` +
+		`w:x="` + twips(addressX) + `" w:y="` + twips(config.AddressSectionY) + `"/>`
+	addressLine := twips(config.LineHeightAddress)
+	paragraph(docxParagraph{frame: addressFrame, borderBottom: true, line: addressLine, lineRule: "exact",
+		size: config.FontSizeSender, text: strings.Join(config.Sender, ", ")})
+	for _, line := range content.recipient {
+		paragraph(docxParagraph{frame: addressFrame, line: addressLine, lineRule: "exact", size: config.FontSizeAddress,
+			text: line})
+	}
+
+	dateFrame := `<w:framePr w:w="` + twips(210-2*config.Margins) + `" w:wrap="around" w:hAnchor="margin" w:vAnchor="page" ` +
+		`w:x="0" w:y="` + twips(config.DateY) + `"/>`
+	// jc is relative to the direction of the paragraph, end is on the left in right to left letters
+	paragraph(docxParagraph{frame: dateFrame, align: "end", text: config.DatePrefix + config.resolveDate(now)})
+
+	// the text starts a line below the date, the top page margin is 20mm like in the pdf
+	paragraph(docxParagraph{spacingBefore: twips(math.Max(config.DateY+config.LineHeight-20, 0)), bold: true,
+		text: strings.TrimSpace(content.subject)})
+	paragraph(docxParagraph{})
+	for _, line := range content.text {
+		paragraph(docxParagraph{text: line})
+	}
+	if signature != nil {
+		paragraph(docxParagraph{line: "240", lineRule: "auto", drawing: docxDrawing(signature)})
+		paragraph(docxParagraph{})
+	}
+	paragraph(docxParagraph{text: config.GetSenderNameOrEmpty()})
+	if len(config.Enclosures) > 0 {
+		names := MapStrings(config.Enclosures, enclosureName)
+		paragraph(docxParagraph{})
+		paragraph(docxParagraph{text: localize(resolveLocale(config.Locale), "letter.enclosures") + " " + strings.Join(names, ", ")})
+	}
+
+	sb.WriteString(`<w:sectPr><w:pgSz w:w="` + strconv.Itoa(docxPageWidth) + `" w:h="` + strconv.Itoa(docxPageHeight) + `"/>` +
+		`<w:pgMar w:top="` + twips(20) + `" w:right="` + twips(config.Margins) + `" w:bottom="` + twips(20) + `" ` +
+		`w:left="` + twips(config.Margins) + `" w:header="0" w:footer="0" w:gutter="0"/>`)
+	if rtl {
+		sb.WriteString("<w:bidi/>")
+	}
+	sb.WriteString(`</w:sectPr></w:body></w:document>`)
+	return sb.String()
+}
+
+// docxDrawing returns the signature as an inline picture, shown at the size it has in the pdf
+func docxDrawing(signature *signatureImage) string {
+	extent := `cx="` + emus(signature.Width) + `" cy="` + emus(signature.Height) + `"`
+	return `<w:drawing><wp:inline distT="0" distB="0" distL="0" distR="0"><wp:extent ` + extent + `/>` +
+		`<wp:docPr id="1" name="Signature"/><a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture">` +
+		`<pic:pic><pic:nvPicPr><pic:cNvPr id="1" name="signature.jpg"/><pic:cNvPicPr/></pic:nvPicPr>` +
+		`<pic:blipFill><a:blip r:embed="rIdSignature"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>` +
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext ` + extent + `/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>` +
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing>`
+}
+
+// twips converts mm to twentieths of a point, the unit of most lengths in docx
+func twips(mm float64) string {
+	return strconv.Itoa(int(math.Round(mm * 1440 / 25.4)))
+}
+
+// emus converts mm to English Metric Units, the unit of drawings in docx
+func emus(mm float64) string {
+	return strconv.Itoa(int(math.Round(mm * 36000)))
+}
+
+// halfPoints converts a font size in pt to the half points docx measures it in
+func halfPoints(size float64) string {
+	return strconv.Itoa(int(math.Round(size * 2)))
+}
+
+func xmlDocument(root string) []byte {
+	return []byte(xml.Header + root)
+}
+
+// xmlText escapes text for the content of an element
+func xmlText(text string) string {
+	var sb strings.Builder
+	_ = xml.EscapeText(&sb, []byte(text))
+	return sb.String()
+}
+
+// xmlAttribute returns value escaped and quoted as an attribute value
+func xmlAttribute(value string) string {
+	return `"` + xmlText(value) + `"`
+}
@@ -0,0 +1,135 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// renderDocxFile renders letter and returns the parts of the docx by name, every xml part has to be well-formed
+func renderDocxFile(t *testing.T, letter string) (map[string]string, []byte) {
+	inputFile, err := renderTestLetter(t, letter, "docx", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := readTestOutput(t, inputFile, "docx")
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	for i, file := range archive.File {
+		if i == 0 {
+			AssertEquals(t, file.Name, "[Content_Types].xml", "first part")
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = reader.Close()
+		parts[file.Name] = string(content)
+		if strings.HasSuffix(file.Name, ".xml") || strings.HasSuffix(file.Name, ".rels") {
+			decoder := xml.NewDecoder(bytes.NewReader(content))
+			for {
+				if _, err = decoder.Token(); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatalf("%s is not well-formed: %v", file.Name, err)
+				}
+			}
+		}
+	}
+	return parts, data
+}
+
+func TestRenderDocx(t *testing.T) {
+	parts, _ := renderDocxFile(t, sampleLetter)
+	document := parts["word/document.xml"]
+	for _, want := range []string{
+		`<w:framePr w:w="3969" w:wrap="around" w:hAnchor="page" w:vAnchor="page" w:x="1417" w:y="2580"/>`,
+		`<w:t xml:space="preserve">Me, Here</w:t>`,
+		`<w:t xml:space="preserve">There</w:t>`,
+		`<w:framePr w:w="9071" w:wrap="around" w:hAnchor="margin" w:vAnchor="page" w:x="0" w:y="5669"/>`,
+		`<w:t xml:space="preserve">Here, 01.06.2023</w:t>`,
+		`<w:t xml:space="preserve">About &lt;b&gt;tags&lt;/b&gt;</w:t>`,
+		`<w:t xml:space="preserve">body  text</w:t>`,
+		`<wp:extent cx="3790950" cy="581025"/>`,
+		`<w:t xml:space="preserve">Me &amp; Co</w:t>`,
+		`<w:pgMar w:top="1134" w:right="1417" w:bottom="1134" w:left="1417" w:header="0" w:footer="0" w:gutter="0"/>`,
+	} {
+		if !strings.Contains(document, want) {
+			t.Errorf("the document does not contain %s:\n%s", want, document)
+		}
+	}
+	styles := parts["word/styles.xml"]
+	for _, want := range []string{
+		`w:ascii="DejaVu Sans Condensed"`,
+		`<w:sz w:val="24"/>`,
+		`<w:lang w:val="de" w:bidi="de"/>`,
+		`<w:spacing w:before="0" w:after="0" w:line="454" w:lineRule="exact"/>`,
+	} {
+		if !strings.Contains(styles, want) {
+			t.Errorf("the styles do not contain %s:\n%s", want, styles)
+		}
+	}
+	if !strings.Contains(parts["docProps/core.xml"], "<dc:title>About &lt;b&gt;tags&lt;/b&gt;</dc:title><dc:creator>Me &amp; Co</dc:creator>") {
+		t.Errorf("wrong core properties:\n%s", parts["docProps/core.xml"])
+	}
+	if !strings.Contains(parts["word/_rels/document.xml.rels"], `Target="media/signature.jpg"`) || parts["word/media/signature.jpg"] == "" {
+		t.Error("the signature is missing")
+	}
+}
+
+func TestRenderDocxRightToLeft(t *testing.T) {
+	parts, _ := renderDocxFile(t, rightToLeftLetter)
+	document := parts["word/document.xml"]
+	for _, want := range []string{
+		`w:x="6520" w:y="2835"/>`,
+		`<w:bidi/>`,
+		`<w:rtl/>`,
+		`<w:t xml:space="preserve">Enclosures: invoice</w:t>`,
+	} {
+		if !strings.Contains(document, want) {
+			t.Errorf("the document does not contain %s:\n%s", want, document)
+		}
+	}
+	if _, found := parts["word/media/signature.jpg"]; found {
+		t.Error("a letter without signature has no image")
+	}
+}
+
+func TestRenderDocxReproducible(t *testing.T) {
+	t.Setenv(sourceDateEpochVariable, "1685570400")
+	_, first := renderDocxFile(t, minimalLetter)
+	_, second := renderDocxFile(t, minimalLetter)
+	if !bytes.Equal(first, second) {
+		t.Error("rendering the same letter twice yields different files")
+	}
+}
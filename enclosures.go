@@ -0,0 +1,163 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/go-pdf/fpdf/contrib/gofpdi"
+)
+
+// enclosureName is how an enclosure is listed below the signature, its file name without extension
+func enclosureName(fileName string) string {
+	base := filepath.Base(fileName)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// appendEnclosures adds the files listed in Enclosures as pages after the letter: every page of a pdf and every
+// image on a page of its own
+func appendEnclosures(pdf *fpdf.Fpdf, config Config) error {
+	importer := gofpdi.NewImporter()
+	for _, enclosure := range config.Enclosures {
+		if _, err := os.Stat(enclosure); err != nil {
+			return &configError{Field: "Enclosures", Err: describeFileError(enclosure, err)}
+		}
+		var err error
+		switch strings.ToLower(filepath.Ext(enclosure)) {
+		case ".pdf":
+			err = appendPdfPages(pdf, importer, enclosure)
+		case ".jpg", ".jpeg", ".png", ".gif":
+			err = appendImagePage(pdf, enclosure, config.Margins)
+		default:
+			err = fmt.Errorf("%s is neither a pdf nor a jpeg, png or gif image", enclosure)
+		}
+		if err != nil {
+			return &configError{Field: "Enclosures", Err: err}
+		}
+	}
+	return nil
+}
+
+// checkPdfaEnclosures rejects the enclosures a PDF/A file of the given level cannot contain: pdf files, whose pages
+// left cannot make conform, and, for PDF/A-1, which forbids transparency, png images with an alpha channel
+func checkPdfaEnclosures(enclosures []string, pdfa *pdfaLevel) error {
+	for _, enclosure := range enclosures {
+		switch strings.ToLower(filepath.Ext(enclosure)) {
+		case ".pdf":
+			return &configError{Field: "Enclosures", Err: fmt.Errorf("%s cannot be enclosed in a PDF/A file, as its pages may not conform, "+
+				"enclose them as images instead", enclosure)}
+		case ".png":
+			if pdfa.Part > 1 {
+				continue
+			}
+			alpha, err := pngHasAlpha(enclosure)
+			if err != nil {
+				return &configError{Field: "Enclosures", Err: err}
+			}
+			if alpha {
+				return &configError{Field: "Enclosures", Err: fmt.Errorf("%s has an alpha channel, which PDF/A-1 does not allow", enclosure)}
+			}
+		}
+	}
+	return nil
+}
+
+// pngHasAlpha reports whether the color type in the header of a png file has an alpha channel
+func pngHasAlpha(fileName string) (bool, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, describeFileError(fileName, err)
+	}
+	defer file.Close()
+	// the signature, the length and type of the IHDR chunk, width, height and bit depth come before the color type
+	header := make([]byte, 26)
+	if _, err = io.ReadFull(file, header); err != nil || string(header[12:16]) != "IHDR" {
+		return false, fmt.Errorf("%s is not a png image", fileName)
+	}
+	const colorTypeAlpha = 4
+	return header[25]&colorTypeAlpha != 0, nil
+}
+
+// appendPdfPages copies all pages of fileName keeping their size
+func appendPdfPages(pdf *fpdf.Fpdf, importer *gofpdi.Importer, fileName string) (err error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	// gofpdi loops forever if there is no startxref in the last 1500 bytes and panics on other broken files
+	if !bytes.Contains(data[:minInt(len(data), 1024)], []byte("%PDF-")) ||
+		!bytes.Contains(data[len(data)-minInt(len(data), 1500):], []byte("startxref")) {
+		return fmt.Errorf("%s is not a pdf file", fileName)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", fileName, r)
+		}
+	}()
+	template := importer.ImportPage(pdf, fileName, 1, "/MediaBox")
+	sizes := importer.GetPageSizes()
+	for page := 1; page <= len(sizes); page++ {
+		if page > 1 {
+			template = importer.ImportPage(pdf, fileName, page, "/MediaBox")
+		}
+		box := sizes[page]["/MediaBox"]
+		width, height := pdf.PointToUnitConvert(box["w"]), pdf.PointToUnitConvert(box["h"])
+		pdf.AddPageFormat("P", fpdf.SizeType{Wd: width, Ht: height})
+		importer.UseImportedTemplate(pdf, template, 0, 0, width, height)
+	}
+	if pdf.Err() {
+		return fmt.Errorf("%s: %s", fileName, pdf.Error())
+	}
+	return nil
+}
+
+// appendImagePage puts an image on a new page, shrinking it to fit within the margins
+func appendImagePage(pdf *fpdf.Fpdf, fileName string, margins float64) error {
+	opt := fpdf.ImageOptions{ReadDpi: true}
+	info := pdf.RegisterImageOptions(fileName, opt)
+	if pdf.Err() {
+		return fmt.Errorf("%s: %s", fileName, pdf.Error())
+	}
+	pdf.AddPage()
+	pageWidth, pageHeight := pdf.GetPageSize()
+	maxWidth, maxHeight := pageWidth-2*margins, pageHeight-2*margins
+	width, height := info.Width(), info.Height()
+	if scale := maxWidth / width; scale < 1 {
+		width, height = width*scale, height*scale
+	}
+	if scale := maxHeight / height; scale < 1 {
+		width, height = width*scale, height*scale
+	}
+	pdf.ImageOptions(fileName, (pageWidth-width)/2, margins, width, height, false, opt, 0, "")
+	return nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
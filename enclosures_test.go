@@ -0,0 +1,98 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestEnclosures(t *testing.T) {
+	dir := t.TempDir()
+	// another letter serves as the pdf enclosure
+	enclosure := filepath.Join(dir, "contract.left")
+	if err := os.WriteFile(enclosure, []byte("// config\n{}\n// address\nName\n// subject\nContract\n// body\nBody\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := render(enclosure, defaultConfig, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	image := filepath.Join(cwd, "test", "it", "pdf", "Signature.jpg")
+	letter := "// config\n{\"Enclosures\": [\"" + outputFileName(enclosure) + "\", \"" + image + "\"]}\n// address\nName\n// subject\nSubject\n// body\nBody\n"
+	data := renderPdf(t, letter, Options{})
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := 0
+	for _, object := range objects {
+		if object.name("Type") == "Page" {
+			pages++
+		}
+	}
+	AssertEquals(t, pages, 3, "letter, contract and image page")
+	images := regexp.MustCompile(`/Subtype /Image`).FindAllIndex(data, -1)
+	AssertEquals(t, len(images), 1, "images")
+	templates := regexp.MustCompile(`/Subtype /Form`).FindAllIndex(data, -1)
+	AssertEquals(t, len(templates), 1, "imported pages")
+}
+
+func TestPdfaEnclosures(t *testing.T) {
+	dir := t.TempDir()
+	writePng := func(name string, alpha uint8) string {
+		picture := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+		for i := range picture.Pix {
+			picture.Pix[i] = 0xff
+		}
+		picture.SetNRGBA(0, 0, color.NRGBA{A: alpha})
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err = png.Encode(file, picture); err != nil {
+			t.Fatal(err)
+		}
+		return file.Name()
+	}
+	opaque, transparent := writePng("opaque.png", 0xff), writePng("transparent.png", 0)
+	contract := filepath.Join(dir, "contract.pdf")
+	enclose := func(level string, enclosure string) error {
+		_, err := renderTestLetter(t, letterWithConfig(`{"PDFA": "`+level+`", "Enclosures": ["`+enclosure+`"]}`), "pdf", Options{})
+		return err
+	}
+	AssertEquals(t, enclose("2b", contract).Error(),
+		"Enclosures: "+contract+" cannot be enclosed in a PDF/A file, as its pages may not conform, enclose them as images instead", "pdf")
+	AssertEquals(t, enclose("1b", transparent).Error(),
+		"Enclosures: "+transparent+" has an alpha channel, which PDF/A-1 does not allow", "png with alpha in PDF/A-1")
+	AssertEquals(t, enclose("2b", transparent), nil, "png with alpha in PDF/A-2")
+	AssertEquals(t, enclose("1b", opaque), nil, "opaque png in PDF/A-1")
+}
+
+func TestEnclosureName(t *testing.T) {
+	AssertEquals(t, enclosureName("invoices/2023/Invoice 4711.pdf"), "Invoice 4711", "name")
+	AssertEquals(t, enclosureName("scan.final.JPG"), "scan.final", "name with dots")
+}
@@ -0,0 +1,277 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// faceIndexSuffix selects a face of a font collection by appending its index to the file name,
+// e.g. "NotoSansCJK-Regular.ttc#2"
+var faceIndexSuffix = regexp.MustCompile(`(?i)^(.*\.(?:ttc|otc))#(\d+)$`)
+
+// splitFaceIndex separates the file name of a font from the index of the face to use, which is 0 unless specified
+func splitFaceIndex(fileName string) (string, int) {
+	match := faceIndexSuffix.FindStringSubmatch(fileName)
+	if match == nil {
+		return fileName, 0
+	}
+	face, err := strconv.Atoi(match[2])
+	if err != nil {
+		return fileName, 0
+	}
+	return match[1], face
+}
+
+// loadFontFile reads a TrueType or OpenType font or a face of a font collection and returns it as a TrueType font
+// that fpdf can embed
+func loadFontFile(fileName string) ([]byte, error) {
+	path, face := splitFaceIndex(fileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, describeFileError(path, err)
+	}
+	result, err := toTrueType(data, face)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fileName, err)
+	}
+	return result, nil
+}
+
+// toTrueType converts the given face of a font file to a single TrueType font. Faces of collections are extracted
+// and CFF outlines are converted to TrueType outlines, as fpdf supports neither.
+func toTrueType(data []byte, face int) ([]byte, error) {
+	if len(data) >= 4 && (string(data[0:4]) == "wOFF" || string(data[0:4]) == "wOF2") {
+		return nil, errors.New("WOFF web fonts are not supported, use the .ttf or .otf version of the font instead")
+	}
+	font, err := parseTrueTypeFace(data, face)
+	if err != nil {
+		return nil, err
+	}
+	if format4, _, err := font.unicodeCmaps(); err != nil || format4 == nil {
+		return nil, errors.New("the font has no unicode cmap subtable in format 4")
+	}
+	_, cff := font.tables["CFF "]
+	_, cff2 := font.tables["CFF2"]
+	switch {
+	case font.hasOutlines() && string(data[0:4]) == signatureCollection:
+		return font.extract(), nil
+	case font.hasOutlines():
+		return data, nil
+	case cff:
+		return convertCffToTrueType(font)
+	case cff2:
+		return nil, errors.New("variable fonts with CFF2 outlines are not supported")
+	default:
+		return nil, errors.New("the font has neither TrueType nor CFF outlines")
+	}
+}
+
+// extract returns the tables of the font as a font file of its own
+func (f *trueTypeFont) extract() []byte {
+	tables := make(map[string][]byte, len(f.tables))
+	for tag := range f.tables {
+		tables[tag], _ = f.table(tag)
+	}
+	signature := signatureTrueType
+	if !f.hasOutlines() {
+		signature = signatureOpenType
+	}
+	return assembleFont(signature, tables)
+}
+
+type outlinePoint struct {
+	x, y    float64
+	onCurve bool
+}
+
+// convertCffToTrueType replaces the CFF outlines of font by quadratic TrueType outlines. Hinting is lost in the
+// process, which does not matter for printing.
+func convertCffToTrueType(font *trueTypeFont) ([]byte, error) {
+	outlines, err := sfnt.Parse(font.extract())
+	if err != nil {
+		return nil, err
+	}
+	unitsPerEm := outlines.UnitsPerEm()
+	var buffer sfnt.Buffer
+	var glyf []byte
+	loca := []byte{0, 0, 0, 0}
+	maxPoints, maxContours := 0, 0
+	for glyph := 0; glyph < outlines.NumGlyphs(); glyph++ {
+		// at a size of one pixel per font unit, the segments are in font units
+		segments, err := outlines.LoadGlyph(&buffer, sfnt.GlyphIndex(glyph), fixed.I(int(unitsPerEm)), nil)
+		if err != nil {
+			return nil, fmt.Errorf("glyph %d: %s", glyph, err)
+		}
+		contours := segmentsToContours(segments)
+		points := 0
+		for _, contour := range contours {
+			points += len(contour)
+		}
+		if points > maxPoints {
+			maxPoints = points
+		}
+		if len(contours) > maxContours {
+			maxContours = len(contours)
+		}
+		glyf = append(glyf, encodeSimpleGlyph(contours)...)
+		loca = binary.BigEndian.AppendUint32(loca, uint32(len(glyf)))
+	}
+
+	tables := map[string][]byte{"glyf": glyf, "loca": loca}
+	for _, tag := range []string{"cmap", "hhea", "hmtx", "name", "OS/2", "post"} {
+		if table, err := font.table(tag); err == nil {
+			tables[tag] = table
+		}
+	}
+	head, err := font.table("head")
+	if err != nil || len(head) < 54 {
+		return nil, errors.New("missing or truncated head table")
+	}
+	head = append([]byte{}, head...)
+	// long loca offsets, glyph data format 0
+	binary.BigEndian.PutUint16(head[50:], 1)
+	binary.BigEndian.PutUint16(head[52:], 0)
+	tables["head"] = head
+	maxp := make([]byte, 32)
+	binary.BigEndian.PutUint32(maxp[0:], 0x00010000)
+	binary.BigEndian.PutUint16(maxp[4:], uint16(outlines.NumGlyphs()))
+	binary.BigEndian.PutUint16(maxp[6:], uint16(maxPoints))
+	binary.BigEndian.PutUint16(maxp[8:], uint16(maxContours))
+	// maxZones
+	binary.BigEndian.PutUint16(maxp[14:], 2)
+	tables["maxp"] = maxp
+	return assembleFont(signatureTrueType, tables), nil
+}
+
+// segmentsToContours converts outline segments to closed contours of on and off curve points, approximating cubic
+// curves by quadratic ones
+func segmentsToContours(segments sfnt.Segments) [][]outlinePoint {
+	var contours [][]outlinePoint
+	var current []outlinePoint
+	point := func(p fixed.Point26_6, onCurve bool) outlinePoint {
+		// sfnt's y axis points down
+		return outlinePoint{x: float64(p.X) / 64, y: -float64(p.Y) / 64, onCurve: onCurve}
+	}
+	closeContour := func() {
+		if len(current) > 1 {
+			first, last := current[0], current[len(current)-1]
+			if last.onCurve && math.Round(first.x) == math.Round(last.x) && math.Round(first.y) == math.Round(last.y) {
+				current = current[:len(current)-1]
+			}
+		}
+		if len(current) > 0 {
+			contours = append(contours, current)
+		}
+		current = nil
+	}
+	for _, segment := range segments {
+		switch segment.Op {
+		case sfnt.SegmentOpMoveTo:
+			closeContour()
+			current = append(current, point(segment.Args[0], true))
+		case sfnt.SegmentOpLineTo:
+			current = append(current, point(segment.Args[0], true))
+		case sfnt.SegmentOpQuadTo:
+			current = append(current, point(segment.Args[0], false), point(segment.Args[1], true))
+		case sfnt.SegmentOpCubeTo:
+			start := current[len(current)-1]
+			current = appendCubic(current, start, point(segment.Args[0], false), point(segment.Args[1], false),
+				point(segment.Args[2], true), 0)
+		}
+	}
+	closeContour()
+	return contours
+}
+
+// appendCubic appends quadratic curves approximating the cubic curve p0 p1 p2 p3 to within half a font unit
+func appendCubic(points []outlinePoint, p0, p1, p2, p3 outlinePoint, depth int) []outlinePoint {
+	// the distance between the cubic curve and its best single quadratic approximation
+	dx := p3.x - 3*p2.x + 3*p1.x - p0.x
+	dy := p3.y - 3*p2.y + 3*p1.y - p0.y
+	if math.Sqrt(3)/36*math.Hypot(dx, dy) <= 0.5 || depth >= 6 {
+		control := outlinePoint{
+			x: (3*(p1.x+p2.x) - p0.x - p3.x) / 4,
+			y: (3*(p1.y+p2.y) - p0.y - p3.y) / 4,
+		}
+		return append(points, control, p3)
+	}
+	mid := func(a, b outlinePoint) outlinePoint {
+		return outlinePoint{x: (a.x + b.x) / 2, y: (a.y + b.y) / 2}
+	}
+	p01, p12, p23 := mid(p0, p1), mid(p1, p2), mid(p2, p3)
+	p012, p123 := mid(p01, p12), mid(p12, p23)
+	center := mid(p012, p123)
+	center.onCurve = true
+	points = appendCubic(points, p0, p01, p012, center, depth+1)
+	return appendCubic(points, center, p123, p23, p3, depth+1)
+}
+
+// encodeSimpleGlyph writes contours as a simple glyph of the glyf table, without instructions and compression
+func encodeSimpleGlyph(contours [][]outlinePoint) []byte {
+	if len(contours) == 0 {
+		return nil
+	}
+	xMin, yMin, xMax, yMax := math.MaxInt16, math.MaxInt16, math.MinInt16, math.MinInt16
+	var flags, xs, ys, endPoints []byte
+	x, y, count := 0, 0, 0
+	for _, contour := range contours {
+		for _, p := range contour {
+			px, py := int(math.Round(p.x)), int(math.Round(p.y))
+			xMin, xMax = int(math.Min(float64(xMin), float64(px))), int(math.Max(float64(xMax), float64(px)))
+			yMin, yMax = int(math.Min(float64(yMin), float64(py))), int(math.Max(float64(yMax), float64(py)))
+			flag := byte(0)
+			if p.onCurve {
+				flag = 1
+			}
+			flags = append(flags, flag)
+			xs = binary.BigEndian.AppendUint16(xs, uint16(int16(px-x)))
+			ys = binary.BigEndian.AppendUint16(ys, uint16(int16(py-y)))
+			x, y = px, py
+			count++
+		}
+		endPoints = binary.BigEndian.AppendUint16(endPoints, uint16(count-1))
+	}
+	glyph := make([]byte, 10)
+	binary.BigEndian.PutUint16(glyph[0:], uint16(len(contours)))
+	binary.BigEndian.PutUint16(glyph[2:], uint16(int16(xMin)))
+	binary.BigEndian.PutUint16(glyph[4:], uint16(int16(yMin)))
+	binary.BigEndian.PutUint16(glyph[6:], uint16(int16(xMax)))
+	binary.BigEndian.PutUint16(glyph[8:], uint16(int16(yMax)))
+	glyph = append(glyph, endPoints...)
+	// no instructions
+	glyph = append(glyph, 0, 0)
+	glyph = append(glyph, flags...)
+	glyph = append(glyph, xs...)
+	glyph = append(glyph, ys...)
+	for len(glyph)%4 != 0 {
+		glyph = append(glyph, 0)
+	}
+	return glyph
+}
@@ -0,0 +1,182 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildCollection combines single font files to a TrueType collection
+func buildCollection(t *testing.T, files ...string) []byte {
+	header := make([]byte, 12+4*len(files))
+	copy(header, signatureCollection)
+	binary.BigEndian.PutUint32(header[4:], 0x00010000)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(files)))
+	var directories, tables []byte
+	var fonts []*trueTypeFont
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		font, err := parseTrueType(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fonts = append(fonts, font)
+	}
+	directoriesSize := 0
+	for _, font := range fonts {
+		directoriesSize += 12 + 16*len(font.tables)
+	}
+	for i, font := range fonts {
+		binary.BigEndian.PutUint32(header[12+4*i:], uint32(len(header)+len(directories)))
+		directory := append([]byte{}, font.data[:12+16*len(font.tables)]...)
+		for j := 0; j < len(font.tables); j++ {
+			record := directory[12+16*j:]
+			table, _ := font.table(string(record[0:4]))
+			binary.BigEndian.PutUint32(record[8:], uint32(len(header)+directoriesSize+len(tables)))
+			tables = append(tables, table...)
+			for len(tables)%4 != 0 {
+				tables = append(tables, 0)
+			}
+		}
+		directories = append(directories, directory...)
+	}
+	return append(append(header, directories...), tables...)
+}
+
+func TestSplitFaceIndex(t *testing.T) {
+	for fileName, want := range map[string]struct {
+		path string
+		face int
+	}{
+		"NotoSansCJK-Regular.ttc#2": {"NotoSansCJK-Regular.ttc", 2},
+		"fonts/Fira.OTC#0":          {"fonts/Fira.OTC", 0},
+		"fonts/Fira.ttc":            {"fonts/Fira.ttc", 0},
+		"fonts/C#.ttf":              {"fonts/C#.ttf", 0},
+		"fonts/Sans.ttf#1":          {"fonts/Sans.ttf#1", 0},
+	} {
+		path, face := splitFaceIndex(fileName)
+		AssertEquals(t, path, want.path, fileName+" path")
+		AssertEquals(t, face, want.face, fileName+" face")
+	}
+}
+
+func TestConvertCffToTrueType(t *testing.T) {
+	data, err := os.ReadFile("test/fonts/CFFTest.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := toTrueType(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, _ := parseTrueType(data)
+	font, err := parseTrueType(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, font.hasOutlines(), true, "has TrueType outlines")
+	originalRunes, _ := original.runes()
+	runes, err := font.runes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, len(runes), len(originalRunes), "number of characters")
+	for r := range originalRunes {
+		AssertEquals(t, runes[r], true, "has "+string(r))
+	}
+}
+
+func TestFontCollectionFaces(t *testing.T) {
+	collection := buildCollection(t, "test/fonts/NotoSans-Condensed.ttf", "test/fonts/NotoSans-CondensedBold.ttf")
+	AssertEquals(t, collectionFaces(collection), 2, "faces")
+	for face, style := range []string{"Regular", "Bold"} {
+		data, err := toTrueType(collection, face)
+		if err != nil {
+			t.Fatal(err)
+		}
+		font, err := parseTrueType(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, subfamily, _ := font.family()
+		AssertEquals(t, subfamily, style, "style")
+	}
+	_, err := toTrueType(collection, 2)
+	AssertEquals(t, err.Error(), "font collection has no face 2, it has 2 faces", "error")
+
+	dir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, "NotoSans.ttc"), collection, 0644); err != nil {
+		t.Fatal(err)
+	}
+	families := groupFontFamilies(scanFonts([]string{dir}))
+	family, found := findFontFamily(families, "Noto Sans Condensed")
+	if !found {
+		t.Fatalf("Font family not found in %v", families)
+	}
+	AssertEquals(t, family.Regular, filepath.Join(dir, "NotoSans.ttc#0"), "Regular")
+	AssertEquals(t, family.Bold, filepath.Join(dir, "NotoSans.ttc#1"), "Bold")
+}
+
+func TestUnsupportedFontFormats(t *testing.T) {
+	_, err := toTrueType([]byte("wOF2 and some more bytes"), 0)
+	AssertEquals(t, err.Error(), "WOFF web fonts are not supported, use the .ttf or .otf version of the font instead", "woff")
+	_, err = toTrueType([]byte("%!PS-AdobeFont-1.0: Type1"), 0)
+	AssertEquals(t, err.Error(), `unsupported font format (signature "%!PS")`, "type 1")
+	data, _ := os.ReadFile("test/fonts/NotoSans-Condensed.ttf")
+	_, err = toTrueType(data, 1)
+	AssertEquals(t, err.Error(), "face 1 requested, but the file is not a font collection", "face of a single font")
+}
+
+func TestRenderWithOpenTypeAndCollectionFonts(t *testing.T) {
+	dir := t.TempDir()
+	collection := buildCollection(t, "test/fonts/NotoSans-Condensed.ttf", "test/fonts/NotoSans-CondensedBold.ttf")
+	if err := os.WriteFile(filepath.Join(dir, "NotoSans.ttc"), collection, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	for _, fontImport := range []string{
+		`{"Name": "cff", "Directory": "` + filepath.Join(cwd, "test/fonts") + `", "FontFileName": "CFFTest.otf"}`,
+		`{"Name": "cff", "Directory": "` + dir + `", "FontFileName": "NotoSans.ttc#1", "FontFileNameBold": "NotoSans.ttc#0"}`,
+	} {
+		stats := renderStats(t, "// config\n{\"FontName\": \"cff\", \"FontImport\": "+fontImport+
+			", \"FontFallback\": [\"helvetica\"]}\n// address\n01\n// subject\nQ\n// body\n10\n")
+		if !Contains(fontNames(stats), "utf8cff") || !Contains(fontNames(stats), "utf8cffB") {
+			t.Errorf("%s: imported font not embedded: %v", fontImport, fontNames(stats))
+		}
+	}
+
+	inputFile := filepath.Join(dir, "input.left")
+	letter := "// config\n{\"FontName\": \"broken\", \"FontImport\": {\"Name\": \"broken\", \"Directory\": \"" + dir +
+		"\", \"FontFileName\": \"input.left\"}}\n// address\nName\n// subject\nSubject\n// body\nBody\n"
+	if err := os.WriteFile(inputFile, []byte(letter), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := render(inputFile, defaultConfig, Options{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported font format") {
+		t.Errorf("expected an unsupported font format error, got %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"github.com/go-pdf/fpdf"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// coreFonts are the standard fonts built into every pdf viewer. They only support the cp1252 character set.
+var coreFonts = []string{"courier", "helvetica", "arial", "times", "symbol", "zapfdingbats"}
+
+// fileName returns the file of the face to use for an fpdf style string, falling back to the closest face available
+func (f FontImport) fileName(style string) string {
+	_, fileName := f.face(style)
+	return fileName
+}
+
+// face returns the name of the field specifying the face to use for an fpdf style string, and its value
+func (f FontImport) face(style string) (string, string) {
+	candidates := map[string][]string{
+		"":   {"FontFileName"},
+		"B":  {"FontFileNameBold", "FontFileName"},
+		"I":  {"FontFileNameItalic", "FontFileName"},
+		"BI": {"FontFileNameBoldItalic", "FontFileNameBold", "FontFileNameItalic", "FontFileName"},
+	}
+	values := map[string]string{
+		"FontFileName":           f.FontFileName,
+		"FontFileNameBold":       f.FontFileNameBold,
+		"FontFileNameItalic":     f.FontFileNameItalic,
+		"FontFileNameBoldItalic": f.FontFileNameBoldItalic,
+	}
+	for _, candidate := range candidates[style] {
+		if values[candidate] != "" {
+			return candidate, values[candidate]
+		}
+	}
+	return "FontFileName", f.FontFileName
+}
+
+// familyNames are the family names the embedded and core fonts are known by in other applications, along with the
+// generic css family they belong to
+var familyNames = map[string]struct{ family, generic string }{
+	"dejavusanscondensed": {"DejaVu Sans Condensed", "sans-serif"},
+	"freeserif":           {"FreeSerif", "serif"},
+	"courier":             {"Courier", "monospace"},
+	"helvetica":           {"Helvetica", "sans-serif"},
+	"arial":               {"Arial", "sans-serif"},
+	"times":               {"Times", "serif"},
+	"symbol":              {"Symbol", "serif"},
+	"zapfdingbats":        {"ZapfDingbats", "serif"},
+}
+
+// fontFamilyName returns the family name of a font as other applications know it
+func fontFamilyName(name string) string {
+	if known, found := familyNames[strings.ToLower(name)]; found {
+		return known.family
+	}
+	return name
+}
+
+type fontRun struct {
+	family string
+	text   string
+}
+
+// embeddedFonts are the utf8 fonts shipped with left
+var embeddedFonts = []string{"dejavusanscondensed", "freeserif"}
+
+// fontChain selects, for every character, the first font in families that has a glyph for it
+type fontChain struct {
+	families []string
+	coverage map[string]func(r rune) bool
+	// translators convert text to the encoding each font expects
+	translators map[string]func(string) string
+	// register adds a style of a font to the pdf, core fonts need no registration
+	register map[string]func(style string)
+	// faces return the TrueType font file of a style of a font, core fonts have none
+	faces map[string]func(style string) ([]byte, error)
+}
+
+// newFontChain resolves FontName and FontFallback to embedded, imported, core or installed fonts
+func newFontChain(pdf *fpdf.Fpdf, config Config) (fontChain, error) {
+	chain := fontChain{
+		coverage:    map[string]func(r rune) bool{},
+		translators: map[string]func(string) string{},
+		register:    map[string]func(style string){},
+		faces:       map[string]func(style string) ([]byte, error){},
+	}
+	// fpdf only supports characters of the basic multilingual plane and panics on any others
+	basicMultilingualPlane := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r > 0xFFFF {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	addTrueType := func(family string, data []byte, face func(style string) ([]byte, error), register func(style string)) error {
+		coverage, err := trueTypeCoverage(data)
+		if err != nil {
+			return err
+		}
+		chain.coverage[family] = coverage
+		chain.translators[family] = basicMultilingualPlane
+		chain.register[family] = register
+		chain.faces[family] = face
+		return nil
+	}
+	// importFont imports the faces of a font from files, field names the config field responsible for a face
+	importFont := func(family string, fontImport FontImport, field func(style string) string) error {
+		files := map[string][]byte{}
+		load := func(style string) ([]byte, error) {
+			fileName := filepath.Join(fontImport.Directory, fontImport.fileName(style))
+			data, ok := files[fileName]
+			if ok {
+				return data, nil
+			}
+			data, err := loadFontFile(fileName)
+			if err != nil {
+				return nil, &configError{Field: field(style), Err: err}
+			}
+			files[fileName] = data
+			return data, nil
+		}
+		data, err := load("")
+		if err != nil {
+			return err
+		}
+		if err = addTrueType(family, data, load, func(style string) {
+			addExternalFont(pdf, family, style, load)
+		}); err != nil {
+			return &configError{Field: field(""), Err: err}
+		}
+		return nil
+	}
+	var installedFonts []systemFontFamily
+	cp1252 := pdf.UnicodeTranslatorFromDescriptor("")
+	for i, name := range append([]string{config.FontName}, config.FontFallback...) {
+		family := strings.ToLower(name)
+		field := "FontFallback"
+		if i == 0 {
+			field = "FontName"
+		}
+		if Contains(chain.families, family) {
+			continue
+		}
+		switch {
+		case Contains(embeddedFonts, family):
+			data, err := fontsDir.ReadFile(fmt.Sprintf("fonts/%s/regular.ttf", family))
+			if err == nil {
+				err = addTrueType(family, data, func(style string) ([]byte, error) {
+					return embeddedFontFile(family, style)
+				}, func(style string) {
+					addEmbeddedFont(pdf, family, style)
+				})
+			}
+			if err != nil {
+				return chain, &configError{Field: field, Err: fmt.Errorf("embedded font %s: %s", family, err)}
+			}
+		case config.FontImport != nil && strings.EqualFold(config.FontImport.Name, name):
+			fontImport := *config.FontImport
+			if err := importFont(family, fontImport, func(style string) string {
+				face, _ := fontImport.face(style)
+				return "FontImport." + face
+			}); err != nil {
+				return chain, err
+			}
+		case Contains(coreFonts, family):
+			if config.PDFA != "" {
+				return chain, &configError{Field: field, Err: fmt.Errorf("%s is a core font, which is not embedded in the pdf as PDF/A requires", name)}
+			}
+			chain.translators[family] = cp1252
+			chain.coverage[family] = translatorCoverage(cp1252)
+		default:
+			if installedFonts == nil {
+				installedFonts = groupFontFamilies(scanFonts(systemFontDirs(runtime.GOOS)))
+			}
+			installed, found := findFontFamily(installedFonts, name)
+			if !found {
+				return chain, &configError{Field: field, Err: fmt.Errorf("unknown font %q, it is neither embedded, imported, a core font nor installed", name)}
+			}
+			if err := importFont(family, installed.fontImport(), func(string) string { return field }); err != nil {
+				return chain, err
+			}
+		}
+		chain.families = append(chain.families, family)
+	}
+	return chain, nil
+}
+
+func (c fontChain) primary() string {
+	return c.families[0]
+}
+
+// split cuts text into runs of characters that can be rendered with the same font.
+// Characters that no font can render are kept in the primary font and returned as missing.
+// Whitespace and combining marks stay with the preceding run.
+func (c fontChain) split(text string) ([]fontRun, []rune) {
+	var runs []fontRun
+	var missing []rune
+	for _, r := range text {
+		family := ""
+		if len(runs) > 0 && (unicode.IsSpace(r) || unicode.Is(unicode.Mn, r)) {
+			family = runs[len(runs)-1].family
+		} else if unicode.IsSpace(r) {
+			family = c.primary()
+		} else {
+			for _, candidate := range c.families {
+				if c.coverage[candidate](r) {
+					family = candidate
+					break
+				}
+			}
+		}
+		if family == "" {
+			if !Contains(missing, r) {
+				missing = append(missing, r)
+			}
+			family = c.primary()
+		}
+		if len(runs) > 0 && runs[len(runs)-1].family == family {
+			runs[len(runs)-1].text += string(r)
+		} else {
+			runs = append(runs, fontRun{family, string(r)})
+		}
+	}
+	return runs, missing
+}
+
+// trueTypeCoverage returns a function reporting whether the font in data has a glyph for a character that fpdf
+// can render
+func trueTypeCoverage(data []byte) (func(r rune) bool, error) {
+	font, err := parseTrueType(data)
+	if err != nil {
+		return nil, err
+	}
+	runes, err := font.runes()
+	if err != nil {
+		return nil, err
+	}
+	return func(r rune) bool {
+		return r <= 0xFFFF && runes[r]
+	}, nil
+}
+
+// translatorCoverage returns a function reporting whether a character survives the code page translation tr
+func translatorCoverage(tr func(string) string) func(r rune) bool {
+	return func(r rune) bool {
+		return r < 0x80 || tr(string(r)) != "."
+	}
+}
+
+// lostCharacters returns the characters of source that a code page translator replaced with its placeholder
+func lostCharacters(source string, translated string) []rune {
+	var lost []rune
+	i := 0
+	for _, r := range source {
+		// code page translators produce exactly one byte per rune
+		if i < len(translated) && translated[i] == '.' && r != '.' && !Contains(lost, r) {
+			lost = append(lost, r)
+		}
+		i++
+	}
+	return lost
+}
+
+// Warning describes a problem that does not prevent the letter from being rendered
+type Warning struct {
+	Section string
+	Line    int
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Line > 0 {
+		return fmt.Sprintf("line %d (%s): %s", w.Line, w.Section, w.Message)
+	}
+	return fmt.Sprintf("%s: %s", w.Section, w.Message)
+}
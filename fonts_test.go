@@ -0,0 +1,68 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"reflect"
+	"testing"
+	"unicode"
+)
+
+func TestFontChainSplit(t *testing.T) {
+	chain := fontChain{
+		families: []string{"latin", "greek"},
+		coverage: map[string]func(r rune) bool{
+			"latin": func(r rune) bool { return r < 0x100 },
+			"greek": func(r rune) bool { return unicode.Is(unicode.Greek, r) },
+		},
+	}
+	runs, missing := chain.split("Ohm Ω and λ ✓")
+	want := []fontRun{{"latin", "Ohm "}, {"greek", "Ω "}, {"latin", "and "}, {"greek", "λ "}, {"latin", "✓"}}
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("runs: got %v, wanted %v", runs, want)
+	}
+	AssertEquals(t, string(missing), "✓", "missing")
+}
+
+func TestTrueTypeCoverage(t *testing.T) {
+	data, err := fontsDir.ReadFile("fonts/dejavusanscondensed/regular.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	covers, err := trueTypeCoverage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range "Aäłש€ﻼ" {
+		AssertEquals(t, covers(r), true, "covers "+string(r))
+	}
+	for _, r := range "一\U0001F600" {
+		AssertEquals(t, covers(r), false, "covers "+string(r))
+	}
+	_, err = trueTypeCoverage([]byte("not a font at all"))
+	if err == nil {
+		t.Errorf("expected an error for invalid font data")
+	}
+}
+
+func TestLostCharacters(t *testing.T) {
+	AssertEquals(t, string(lostCharacters("Zażółć ...", "Za.\xf3.. ...")), "żłć", "lost")
+	AssertEquals(t, string(lostCharacters("a.b", "a.b")), "", "dots are not lost")
+}
@@ -0,0 +1,80 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// formats are the output formats -format selects from, each one writes a letter to a file next to it
+var formats = map[string]func(inputFile string, defaultConfig Config, options Options) ([]Warning, error){
+	"pdf":  render,
+	"html": renderHtml,
+	"txt":  renderText,
+	"md":   renderMarkdown,
+	"docx": renderDocx,
+	"png":  renderPng,
+	"svg":  renderSvg,
+}
+
+// formatNames returns the names of all output formats in alphabetical order
+func formatNames() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatFileName returns the name of the file a format writes inputFile to, the format being its extension
+func formatFileName(inputFile string, format string) string {
+	return strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + "." + format
+}
+
+// signatureImage is a jpeg signature and its size in mm when shown at 96 dpi, as in the pdf
+type signatureImage struct {
+	Data          []byte
+	Width, Height float64
+}
+
+// loadSignature reads the Signature of config, it returns nil if there is none
+func loadSignature(config Config) (*signatureImage, error) {
+	signature := config.GetSignatureOrEmpty()
+	if signature == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(signature)
+	if err != nil {
+		return nil, &configError{Field: "Signature", Err: describeFileError(signature, err)}
+	}
+	size, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" {
+		return nil, &configError{Field: "Signature", Err: fmt.Errorf("%s is not a jpeg image", signature)}
+	}
+	return &signatureImage{Data: data, Width: float64(size.Width) * 25.4 / 96, Height: float64(size.Height) * 25.4 / 96}, nil
+}
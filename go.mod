@@ -2,4 +2,15 @@ module left
 
 go 1.20
 
-require github.com/go-pdf/fpdf v0.8.0 // indirect
+require (
+	github.com/go-pdf/fpdf v0.8.0
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.16.0
+	software.sslmate.com/src/go-pkcs12 v0.4.0
+)
+
+require (
+	github.com/phpdave11/gofpdi v1.0.13 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/crypto v0.11.0 // indirect
+)
@@ -0,0 +1,198 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var htmlTemplate = template.Must(template.New("letter").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}" dir="{{.Dir}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{with .Author}}<meta name="author" content="{{.}}">
+{{end}}<meta name="generator" content="{{.Generator}}">
+<style>
+{{.Style}}</style>
+</head>
+<body>
+<div class="letter">
+<div class="address-section">
+<div class="sender">{{.Sender}}</div>
+<div class="address">
+{{range .Recipient}}<div>{{.}}</div>
+{{end}}</div>
+</div>
+<div class="date">{{.Date}}</div>
+<p class="subject">{{.Subject}}</p>
+{{range .Text}}<p>{{.}}</p>
+{{end}}{{with .Signature}}<img class="signature" src="{{.}}" alt="">
+{{end}}<p class="sender-name">{{.SenderName}}</p>
+{{with .Enclosures}}<p class="enclosures">{{.}}</p>
+{{end}}</div>
+</body>
+</html>
+`))
+
+// htmlLetter is what htmlTemplate fills in
+type htmlLetter struct {
+	Lang, Dir, Title, Author, Generator string
+	Style                               template.CSS
+	Sender                              string
+	Recipient                           []string
+	Date, Subject                       string
+	Text                                []string
+	Signature                           template.URL
+	SenderName, Enclosures              string
+}
+
+// renderHtml writes the letter to a standalone html file for e-mails and previews. Its print style approximates the
+// geometry of the pdf.
+func renderHtml(inputFile string, defaultConfig Config, options Options) ([]Warning, error) {
+	content, err := parseLetter(inputFile, defaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	config := content.config
+	now, _, err := currentTime()
+	if err != nil {
+		return nil, err
+	}
+	locale := resolveLocale(config.Locale)
+	letter := htmlLetter{
+		Lang:       locale,
+		Dir:        "ltr",
+		Title:      config.documentTitle(content.subject),
+		Author:     config.documentAuthor(),
+		Generator:  "left " + strings.TrimSpace(Version()),
+		Sender:     strings.Join(config.Sender, ", "),
+		Recipient:  content.recipient,
+		Date:       config.DatePrefix + config.resolveDate(now),
+		Subject:    content.subject,
+		Text:       content.text,
+		SenderName: config.GetSenderNameOrEmpty(),
+	}
+	if config.isRightToLeft() {
+		letter.Dir = "rtl"
+	}
+	if len(config.Enclosures) > 0 {
+		names := MapStrings(config.Enclosures, enclosureName)
+		letter.Enclosures = localize(locale, "letter.enclosures") + " " + strings.Join(names, ", ")
+	}
+	signature, err := loadSignature(config)
+	if err != nil {
+		return nil, err
+	}
+	var signatureWidth, signatureHeight float64
+	if signature != nil {
+		signatureWidth, signatureHeight = signature.Width, signature.Height
+		letter.Signature = template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(signature.Data))
+	}
+	letter.Style = template.CSS(htmlStyle(config, signatureWidth, signatureHeight))
+
+	var buffer bytes.Buffer
+	if err = htmlTemplate.Execute(&buffer, letter); err != nil {
+		return nil, err
+	}
+	return nil, writeFileAtomic(formatFileName(inputFile, "html"), buffer.Bytes(), 0644)
+}
+
+// htmlStyle returns the style sheet of a letter. Lengths are taken from the config as they are, in mm and pt. The
+// first printed page has no top margin, as the address section and the date are placed relative to the top edge.
+func htmlStyle(config Config, signatureWidth float64, signatureHeight float64) string {
+	// the address section is mirrored for right to left letters
+	side := "left"
+	if config.isRightToLeft() {
+		side = "right"
+	}
+	var sb strings.Builder
+	rule := func(selector string, declarations ...string) {
+		sb.WriteString(selector + " { " + strings.Join(declarations, "; ") + "; }\n")
+	}
+	mm := func(value float64) string {
+		return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64) + "mm"
+	}
+	pt := func(value float64) string {
+		return fmt.Sprintf("%gpt", value)
+	}
+	rule("@page", "size: A4", "margin: 20mm 0")
+	rule("@page :first", "margin-top: 0")
+	rule("html", "font-family: "+cssFontFamily(config), "font-size: "+pt(config.FontSize), "line-height: "+mm(config.LineHeight))
+	rule("body", "margin: 0")
+	rule(".letter", "position: relative", "box-sizing: border-box", "width: 210mm",
+		"padding: "+mm(config.DateY+config.LineHeight)+" "+mm(config.Margins)+" 0")
+	rule(".address-section", "position: absolute", "top: "+mm(config.AddressSectionY), side+": "+mm(config.AddressSectionX),
+		"width: "+mm(config.AddressSectionW), "line-height: "+mm(config.LineHeightAddress))
+	rule(".sender", "font-size: "+pt(config.FontSizeSender), "border-bottom: 0.2mm solid")
+	rule(".address", "font-size: "+pt(config.FontSizeAddress))
+	rule(".address div", "min-height: "+mm(config.LineHeightAddress))
+	rule(".date", "position: absolute", "top: "+mm(config.DateY), "left: "+mm(config.Margins), "right: "+mm(config.Margins), "text-align: end")
+	rule("p", "margin: 0", "min-height: "+mm(config.LineHeight), "white-space: pre-wrap")
+	rule(".subject", "font-weight: bold", "margin-bottom: "+mm(config.LineHeight))
+	if signatureWidth > 0 {
+		rule(".signature", "display: block", "width: "+mm(signatureWidth), "height: "+mm(signatureHeight))
+	}
+	rule(".sender-name, .enclosures", "margin-top: "+mm(config.LineHeight))
+	sb.WriteString("@media screen {\n")
+	rule("  body", "background: #e0e0e0")
+	rule("  .letter", "min-height: 297mm", "margin: 0 auto", "padding-bottom: 20mm", "background: white")
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// cssFontFamily returns the font-family of FontName and FontFallback. Imported and installed fonts keep their
+// names, the embedded and core fonts are given the names they are known by.
+func cssFontFamily(config Config) string {
+	var families []string
+	generic := "sans-serif"
+	for i, name := range append([]string{config.FontName}, config.FontFallback...) {
+		if known, found := familyNames[strings.ToLower(name)]; found {
+			name = known.family
+			if i == 0 {
+				generic = known.generic
+			}
+		}
+		families = append(families, cssString(name))
+	}
+	return strings.Join(append(families, generic), ", ")
+}
+
+// cssString quotes s as a css string that cannot end the style element it is in
+func cssString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\' || r == '<' || r == '>' || r == '&' || r < 0x20:
+			sb.WriteString(fmt.Sprintf("\\%x ", r))
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,89 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func renderHtmlFile(t *testing.T, letter string) (string, error) {
+	inputFile, err := renderTestLetter(t, letter, "html", Options{})
+	if err != nil {
+		return "", err
+	}
+	return string(readTestOutput(t, inputFile, "html")), nil
+}
+
+func TestRenderHtml(t *testing.T) {
+	html, err := renderHtmlFile(t, sampleLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<html lang="de" dir="ltr">`,
+		`<title>About &lt;b&gt;tags&lt;/b&gt;</title>`,
+		`<meta name="author" content="Me &amp; Co">`,
+		`font-family: "DejaVu Sans Condensed", sans-serif; font-size: 12pt; line-height: 8mm;`,
+		`.address-section { position: absolute; top: 45.5mm; left: 25mm; width: 70mm; line-height: 6mm; }`,
+		`.signature { display: block; width: 105.3mm; height: 16.14mm; }`,
+		`<div class="sender">Me, Here</div>`,
+		"<div>You</div>\n<div>There</div>",
+		`<div class="date">Here, 01.06.2023</div>`,
+		`<p class="subject">About &lt;b&gt;tags&lt;/b&gt;</p>`,
+		"<p>Dear you,</p>\n<p></p>\n<p>body  text</p>",
+		`<img class="signature" src="data:image/jpeg;base64,/9j/`,
+		`<p class="sender-name">Me &amp; Co</p>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("the html does not contain %s:\n%s", want, html)
+		}
+	}
+}
+
+func TestRenderHtmlRightToLeft(t *testing.T) {
+	html, err := renderHtmlFile(t, rightToLeftLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`dir="rtl"`,
+		`.address-section { position: absolute; top: 50mm; right: 25mm;`,
+		`<p class="enclosures">Enclosures: invoice</p>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("the html does not contain %s:\n%s", want, html)
+		}
+	}
+}
+
+func TestRenderHtmlErrors(t *testing.T) {
+	_, err := renderHtmlFile(t, letterWithConfig(`{"Signature": "./test/it/pdf/missing.jpg"}`))
+	AssertEquals(t, err.Error(), "Signature: ./test/it/pdf/missing.jpg not found", "missing signature")
+	_, err = renderHtmlFile(t, letterWithConfig(`{"Signature": "./test/it/pdf/01_simple_letter/input.left"}`))
+	AssertEquals(t, err.Error(), "Signature: ./test/it/pdf/01_simple_letter/input.left is not a jpeg image", "no image")
+}
+
+func TestCssFontFamily(t *testing.T) {
+	config := defaultConfig
+	config.FontName = "Times"
+	config.FontFallback = []string{"Noto \"Sans\""}
+	AssertEquals(t, cssFontFamily(config), `"Times", "Noto \22 Sans\22 ", serif`, "font family")
+}
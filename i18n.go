@@ -0,0 +1,203 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const fallbackLocale = "en"
+
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		"usage.title":            "left - generates letter from txt file",
+		"usage.synopsis":         "Usage: left OPTIONS | FILE",
+		"usage.description":      "If a FILE argument is provided the file is used as an input.txt to generate a PDF formatted letter.\nThe text file is expected to consist of four sections: config, address, subject and body (in this order).\nEach section is initiated by a line starting with //\nThe config section contains the letter configuration, formatted in json (Also see OPTIONS).",
+		"usage.options":          "Otherwise the following OPTIONS are available:",
+		"usage.help":             "Prints this help",
+		"flag.version":           "ignore all other arguments, print the left version and exit",
+		"flag.dumpConfig":        "dumps the standard config to stdout",
+		"flag.config":            "custom config file to read from after loading configuration defaults (defaults to $LEFT_CONFIG)",
+		"flag.create":            "prints a template for a new letter to stdout",
+		"flag.listFonts":         "lists the fonts installed on this system that can be used as FontName",
+		"flag.strict":            "treat warnings, e.g. about characters that cannot be rendered, as errors",
+		"flag.stats":             "prints the size of the generated pdf and how much each font contributes to it",
+		"flag.pdfa":              "writes a PDF/A file of the given conformance level (1b or 2b) for archiving, overriding the PDFA setting",
+		"flag.embedSource":       "embeds the letter itself in the pdf, so that it can be recovered with -extract",
+		"flag.extract":           "prints the letter embedded in the given pdf by -embed-source or EmbedSource",
+		"flag.signKey":           "signs the pdf with the key of a PKCS#12 keystore (password in $LEFT_SIGN_PASSWORD) or a PEM file",
+		"flag.signCert":          "PEM file with the certificate for -sign-key, if the key file does not contain it",
+		"flag.userPasswordFile":  "protects the pdf with the password in this file (or in $LEFT_USER_PASSWORD) that is needed to open it",
+		"flag.ownerPasswordFile": "file with the owner password that lifts all restrictions of a protected pdf (or $LEFT_OWNER_PASSWORD)",
+		"flag.permissions":       "what a protected pdf allows without the owner password, e.g. print,copy or none",
+		"flag.reproducible":      "writes the same pdf for the same letter, taking all timestamps from Date or $SOURCE_DATE_EPOCH",
+		"flag.format":            "output format: pdf, html, txt, md, docx, png or svg",
+		"flag.dpi":               "resolution of -format png in dots per inch",
+		"flag.watch":             "render the letter again whenever it, its config files, signature or fonts change",
+		"stats.size":             "%s: %d bytes",
+		"stats.font":             "%s: %d bytes",
+		"stats.coreFont":         "%s: not embedded (core font)",
+		"error.prefix":           "Error",
+		"warning.prefix":         "Warning",
+		"error.exclusive":        "flags %s and %s are mutually exclusive!",
+		"error.positional":       "flag %s is incompatible with positional arguments!",
+		"error.missingArgs":      "Missing arguments.",
+		"error.format":           "unknown format %q, use one of %s",
+		"error.dpi":              "-dpi must be a positive resolution",
+		"watch.started":          "watching %s and the files it depends on, stop with Ctrl+C",
+		"watch.rendered":         "%s rendered at %s",
+		"letter.notes":           "You can put random notes here. Anything before the first section will be ignored.",
+		"letter.sections":        "Config sections are started with a line that begins with //",
+		"letter.name":            "Name",
+		"letter.street":          "Street",
+		"letter.city":            "City",
+		"letter.subject":         "Add your subject here. This section must not have more than one line.",
+		"letter.salutation":      "Dear sir or madam,",
+		"letter.closing":         "Kind regards,",
+		"letter.enclosures":      "Enclosures:",
+	},
+	"de": {
+		"usage.title":            "left - erzeugt Briefe aus Textdateien",
+		"usage.synopsis":         "Verwendung: left OPTIONEN | DATEI",
+		"usage.description":      "Wird ein DATEI-Argument angegeben, wird aus dieser Textdatei ein Brief im PDF-Format erzeugt.\nDie Textdatei besteht aus vier Abschnitten: Konfiguration, Adresse, Betreff und Text (in dieser Reihenfolge).\nJeder Abschnitt beginnt mit einer Zeile, die mit // anfängt.\nDer Konfigurationsabschnitt enthält die Konfiguration des Briefs im json-Format (siehe auch OPTIONEN).",
+		"usage.options":          "Andernfalls stehen folgende OPTIONEN zur Verfügung:",
+		"usage.help":             "Gibt diese Hilfe aus",
+		"flag.version":           "alle anderen Argumente ignorieren, die Version von left ausgeben und beenden",
+		"flag.dumpConfig":        "gibt die Standardkonfiguration auf stdout aus",
+		"flag.config":            "zusätzliche Konfigurationsdatei, die nach den Standardkonfigurationen gelesen wird (Standard: $LEFT_CONFIG)",
+		"flag.create":            "gibt eine Vorlage für einen neuen Brief auf stdout aus",
+		"flag.listFonts":         "listet die auf diesem System installierten Schriftarten auf, die als FontName verwendet werden können",
+		"flag.strict":            "Warnungen, z.B. über nicht darstellbare Zeichen, als Fehler behandeln",
+		"flag.stats":             "gibt die Größe des erzeugten PDFs und den Anteil jeder Schriftart daran aus",
+		"flag.pdfa":              "schreibt eine PDF/A-Datei des angegebenen Konformitätslevels (1b oder 2b) zur Archivierung, statt der Einstellung PDFA",
+		"flag.embedSource":       "bettet den Brief selbst in das PDF ein, damit er mit -extract wiederhergestellt werden kann",
+		"flag.extract":           "gibt den Brief aus, der mit -embed-source oder EmbedSource in das angegebene PDF eingebettet wurde",
+		"flag.signKey":           "signiert das PDF mit dem Schlüssel eines PKCS#12-Keystores (Passwort in $LEFT_SIGN_PASSWORD) oder einer PEM-Datei",
+		"flag.signCert":          "PEM-Datei mit dem Zertifikat zu -sign-key, falls die Schlüsseldatei es nicht enthält",
+		"flag.userPasswordFile":  "schützt das PDF mit dem Passwort aus dieser Datei (oder aus $LEFT_USER_PASSWORD), das zum Öffnen nötig ist",
+		"flag.ownerPasswordFile": "Datei mit dem Besitzerpasswort, das alle Einschränkungen eines geschützten PDFs aufhebt (oder $LEFT_OWNER_PASSWORD)",
+		"flag.permissions":       "was ein geschütztes PDF ohne Besitzerpasswort erlaubt, z.B. print,copy oder none",
+		"flag.reproducible":      "schreibt für denselben Brief dasselbe PDF, alle Zeitstempel stammen aus Date oder $SOURCE_DATE_EPOCH",
+		"flag.format":            "Ausgabeformat: pdf, html, txt, md, docx, png oder svg",
+		"flag.dpi":               "Auflösung von -format png in dpi",
+		"flag.watch":             "den Brief neu erzeugen, sobald er, seine Konfigurationsdateien, die Unterschrift oder Schriften sich ändern",
+		"stats.size":             "%s: %d Bytes",
+		"stats.font":             "%s: %d Bytes",
+		"stats.coreFont":         "%s: nicht eingebettet (Standardschrift)",
+		"error.prefix":           "Fehler",
+		"warning.prefix":         "Warnung",
+		"error.exclusive":        "die Optionen %s und %s schließen sich gegenseitig aus!",
+		"error.positional":       "die Option %s kann nicht mit weiteren Argumenten kombiniert werden!",
+		"error.missingArgs":      "Fehlende Argumente.",
+		"error.format":           "unbekanntes Format %q, möglich sind %s",
+		"error.dpi":              "-dpi muss eine positive Auflösung sein",
+		"watch.started":          "beobachte %s und die Dateien, von denen er abhängt, beenden mit Strg+C",
+		"watch.rendered":         "%s erzeugt um %s",
+		"letter.notes":           "Hier ist Platz für Notizen. Alles vor dem ersten Abschnitt wird ignoriert.",
+		"letter.sections":        "Abschnitte beginnen mit einer Zeile, die mit // anfängt.",
+		"letter.name":            "Name",
+		"letter.street":          "Straße",
+		"letter.city":            "PLZ Ort",
+		"letter.subject":         "Hier steht der Betreff. Dieser Abschnitt darf nur eine Zeile enthalten.",
+		"letter.salutation":      "Sehr geehrte Damen und Herren,",
+		"letter.closing":         "Mit freundlichen Grüßen",
+		"letter.enclosures":      "Anlagen:",
+	},
+	"fr": {
+		"usage.title":            "left - génère des lettres à partir de fichiers texte",
+		"usage.synopsis":         "Utilisation : left OPTIONS | FICHIER",
+		"usage.description":      "Si un argument FICHIER est fourni, ce fichier texte est utilisé pour générer une lettre au format PDF.\nLe fichier texte comporte quatre sections : configuration, adresse, objet et corps (dans cet ordre).\nChaque section commence par une ligne débutant par //\nLa section de configuration contient la configuration de la lettre au format json (voir aussi OPTIONS).",
+		"usage.options":          "Sinon, les OPTIONS suivantes sont disponibles :",
+		"usage.help":             "Affiche cette aide",
+		"flag.version":           "ignore tous les autres arguments, affiche la version de left et quitte",
+		"flag.dumpConfig":        "affiche la configuration standard sur stdout",
+		"flag.config":            "fichier de configuration lu après les configurations par défaut (par défaut : $LEFT_CONFIG)",
+		"flag.create":            "affiche un modèle de nouvelle lettre sur stdout",
+		"flag.listFonts":         "liste les polices installées sur ce système qui peuvent être utilisées comme FontName",
+		"flag.strict":            "traite les avertissements, par exemple sur les caractères non affichables, comme des erreurs",
+		"flag.stats":             "affiche la taille du pdf généré et la part de chaque police",
+		"flag.pdfa":              "écrit un fichier PDF/A du niveau de conformité indiqué (1b ou 2b) pour l'archivage, à la place du paramètre PDFA",
+		"flag.embedSource":       "intègre la lettre elle-même dans le pdf, afin de pouvoir la récupérer avec -extract",
+		"flag.extract":           "affiche la lettre intégrée dans le pdf indiqué par -embed-source ou EmbedSource",
+		"flag.signKey":           "signe le pdf avec la clé d'un magasin PKCS#12 (mot de passe dans $LEFT_SIGN_PASSWORD) ou d'un fichier PEM",
+		"flag.signCert":          "fichier PEM contenant le certificat pour -sign-key, si le fichier de clé ne le contient pas",
+		"flag.userPasswordFile":  "protège le pdf avec le mot de passe de ce fichier (ou de $LEFT_USER_PASSWORD) nécessaire pour l'ouvrir",
+		"flag.ownerPasswordFile": "fichier contenant le mot de passe propriétaire qui lève toutes les restrictions d'un pdf protégé (ou $LEFT_OWNER_PASSWORD)",
+		"flag.permissions":       "ce qu'un pdf protégé permet sans le mot de passe propriétaire, par ex. print,copy ou none",
+		"flag.reproducible":      "écrit le même pdf pour la même lettre, tous les horodatages provenant de Date ou de $SOURCE_DATE_EPOCH",
+		"flag.format":            "format de sortie : pdf, html, txt, md, docx, png ou svg",
+		"flag.dpi":               "résolution de -format png en points par pouce",
+		"flag.watch":             "régénérer la lettre dès qu'elle, ses fichiers de configuration, la signature ou les polices changent",
+		"stats.size":             "%s : %d octets",
+		"stats.font":             "%s : %d octets",
+		"stats.coreFont":         "%s : non incorporée (police standard)",
+		"error.prefix":           "Erreur",
+		"warning.prefix":         "Avertissement",
+		"error.exclusive":        "les options %s et %s s'excluent mutuellement !",
+		"error.positional":       "l'option %s est incompatible avec des arguments positionnels !",
+		"error.missingArgs":      "Arguments manquants.",
+		"error.format":           "format %q inconnu, utilisez l'un des formats %s",
+		"error.dpi":              "-dpi doit être une résolution positive",
+		"watch.started":          "surveillance de %s et des fichiers dont elle dépend, arrêt avec Ctrl+C",
+		"watch.rendered":         "%s générée à %s",
+		"letter.notes":           "Vous pouvez prendre des notes ici. Tout ce qui précède la première section est ignoré.",
+		"letter.sections":        "Les sections commencent par une ligne débutant par //",
+		"letter.name":            "Nom",
+		"letter.street":          "Rue",
+		"letter.city":            "Code postal Ville",
+		"letter.subject":         "Indiquez l'objet ici. Cette section ne doit pas comporter plus d'une ligne.",
+		"letter.salutation":      "Madame, Monsieur,",
+		"letter.closing":         "Veuillez agréer, Madame, Monsieur, l'expression de mes salutations distinguées.",
+		"letter.enclosures":      "Pièces jointes :",
+	},
+}
+
+// cliLocale is the locale used for messages that are printed before any configuration has been read
+var cliLocale = resolveLocale("")
+
+// resolveLocale returns the catalog locale matching the configured locale.
+// An empty locale is taken from the environment (LC_ALL, LC_MESSAGES, LANG) like other command line tools do.
+func resolveLocale(locale string) string {
+	if locale == "" {
+		for _, variable := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+			if locale = os.Getenv(variable); locale != "" {
+				break
+			}
+		}
+	}
+	normalized := normalizeLocale(locale)
+	if _, ok := messageCatalog[normalized]; ok {
+		return normalized
+	}
+	return fallbackLocale
+}
+
+// localize looks up the message for key in the catalog of locale and formats it with args
+func localize(locale string, key string, args ...any) string {
+	message, ok := messageCatalog[locale][key]
+	if !ok {
+		message = messageCatalog[fallbackLocale][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
@@ -0,0 +1,107 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// srgbProfile returns an ICC version 2 display profile of the sRGB IEC61966-2.1 color space, as needed for the
+// output intent of PDF/A files
+func srgbProfile() []byte {
+	s15Fixed16 := func(v float64) []byte {
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(math.Round(v*65536))))
+	}
+	xyz := func(x, y, z float64) []byte {
+		data := append([]byte("XYZ "), 0, 0, 0, 0)
+		data = append(data, s15Fixed16(x)...)
+		data = append(data, s15Fixed16(y)...)
+		return append(data, s15Fixed16(z)...)
+	}
+	description := "sRGB IEC61966-2.1"
+	desc := append([]byte("desc"), 0, 0, 0, 0)
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(description)+1))
+	desc = append(append(desc, description...), 0)
+	// no unicode and no script code description
+	desc = append(desc, make([]byte, 4+4+2+1+67)...)
+	cprt := append(append([]byte("text"), 0, 0, 0, 0), "No copyright, use freely"...)
+	cprt = append(cprt, 0)
+	const trcEntries = 1024
+	trc := append([]byte("curv"), 0, 0, 0, 0)
+	trc = binary.BigEndian.AppendUint32(trc, trcEntries)
+	for i := 0; i < trcEntries; i++ {
+		v := float64(i) / (trcEntries - 1)
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		trc = binary.BigEndian.AppendUint16(trc, uint16(math.Round(v*65535)))
+	}
+	// primaries adapted to the D50 illuminant of the profile connection space
+	tags := []struct {
+		signature string
+		data      []byte
+	}{
+		{"desc", desc},
+		{"cprt", cprt},
+		{"wtpt", xyz(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	binary.BigEndian.PutUint16(header[24:], 2023)
+	binary.BigEndian.PutUint16(header[26:], 1)
+	binary.BigEndian.PutUint16(header[28:], 1)
+	copy(header[36:], "acsp")
+	copy(header[68:], xyz(0.9642, 1.0, 0.8249)[8:])
+
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	var data []byte
+	offset := len(header) + 4 + 12*len(tags)
+	offsets := map[string]int{}
+	for _, tag := range tags {
+		// the tone reproduction curves share their data
+		key := string(tag.data)
+		if _, ok := offsets[key]; !ok {
+			offsets[key] = offset + len(data)
+			data = append(data, tag.data...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		table = append(table, tag.signature...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offsets[key]))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag.data)))
+	}
+	profile := append(append(header, table...), data...)
+	binary.BigEndian.PutUint32(profile[0:], uint32(len(profile)))
+	return profile
+}
@@ -0,0 +1,424 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+letterLayout is the geometry of a letter: what goes where on which page. It is computed from the Config and the
+content of a letter independently of the format it is written in. All lengths are in mm, positions are measured
+from the top left corner of the page.
+*/
+type letterLayout struct {
+	Pages []layoutPage
+	// Signature is the signature image, if the letter has one, placed on page SignaturePage (counting from 1)
+	Signature     *layoutImage
+	SignaturePage int
+	Warnings      []Warning
+}
+
+// layoutPage lists the items of a page in the order they are drawn
+type layoutPage struct {
+	Items []layoutItem
+}
+
+// layoutItem is a layoutText, a layoutImage or a layoutRule
+type layoutItem interface {
+	isLayoutItem()
+}
+
+// layoutText is a single line of text in a cell of the given height. Its runs are set in different fonts where the
+// primary font lacks glyphs and are in display order, i.e. right to left text is already reversed.
+type layoutText struct {
+	Y, Height float64
+	Style     string
+	Size      float64
+	Runs      []layoutRun
+}
+
+// layoutRun is text set in a single font, starting at X
+type layoutRun struct {
+	X, Width float64
+	Family   string
+	Text     string
+}
+
+type layoutImage struct {
+	File                string
+	X, Y, Width, Height float64
+}
+
+// layoutRule is a straight line, e.g. the one separating the sender from the address
+type layoutRule struct {
+	X1, Y1, X2, Y2 float64
+}
+
+func (layoutText) isLayoutItem()  {}
+func (layoutImage) isLayoutItem() {}
+func (layoutRule) isLayoutItem()  {}
+
+// pageGeometry is the page format a letter is laid out on
+type pageGeometry struct {
+	Width, Height float64
+	// Top is where text continues on a new page, no text is placed within Bottom of the lower edge
+	Top, Bottom float64
+	// CellMargin is the space kept between text and the edges of its cell
+	CellMargin float64
+}
+
+// layoutMetrics provides the sizes of text and images, which depend on the backend the letter is written with
+type layoutMetrics interface {
+	// stringWidth returns the width of text in a font. Text is measured one character at a time.
+	stringWidth(family string, style string, size float64, text string) float64
+	// imageSize returns the size of an image placed at 96 dpi
+	imageSize(file string) (float64, float64, error)
+	// err returns the first error the backend ran into, e.g. a font file it could not load
+	err() error
+}
+
+// layouter places the parts of a letter one after another, breaking lines and pages as needed
+type layouter struct {
+	config      Config
+	page        pageGeometry
+	fonts       fontChain
+	metrics     layoutMetrics
+	rightToLeft bool
+	style       string
+	size        float64
+	widths      map[string]float64
+	x, y        float64
+	layout      letterLayout
+}
+
+// layoutLetter computes the layout of a letter, now resolves its date
+func layoutLetter(content letter, page pageGeometry, fonts fontChain, metrics layoutMetrics, now time.Time) (letterLayout, error) {
+	config := content.config
+	l := &layouter{
+		config:      config,
+		page:        page,
+		fonts:       fonts,
+		metrics:     metrics,
+		rightToLeft: config.isRightToLeft(),
+		widths:      map[string]float64{},
+		layout:      letterLayout{Pages: []layoutPage{{}}},
+	}
+	addressSectionX := config.AddressSectionX
+	if l.rightToLeft {
+		addressSectionX = page.Width - config.AddressSectionX - config.AddressSectionW
+	}
+
+	// Sender
+	l.x, l.y = addressSectionX, config.AddressSectionY
+	l.setFont("", config.FontSizeSender)
+	l.multiCell(config.AddressSectionW, config.LineHeightAddress, strings.Join(config.Sender, ", "), "B", "L", Warning{Section: "config: Sender"})
+	if err := l.partError("Sender"); err != nil {
+		return l.layout, err
+	}
+
+	// Address
+	l.setFont("", config.FontSizeAddress)
+	for i := 0; i < len(content.recipient); i++ {
+		l.x = addressSectionX
+		l.multiCell(config.AddressSectionW, config.LineHeightAddress, content.recipient[i], "", "L", Warning{Section: "address", Line: content.recipientLines[i]})
+	}
+	if err := l.partError("address"); err != nil {
+		return l.layout, err
+	}
+
+	l.setFont("", config.FontSize)
+
+	// Date
+	l.x, l.y = config.Margins, config.DateY
+	l.multiCell(0, config.LineHeight, config.DatePrefix+config.resolveDate(now), "", "R", Warning{Section: "config: DatePrefix, Date"})
+	if err := l.partError("Date"); err != nil {
+		return l.layout, err
+	}
+
+	// Subject
+	l.setFont("B", config.FontSize)
+	l.x, l.y = config.Margins, config.DateY+config.LineHeight
+	l.multiCell(0, config.LineHeight, content.subject, "", "L", Warning{Section: "subject", Line: content.subjectLine})
+	l.setFont("", config.FontSize)
+	if err := l.partError("subject"); err != nil {
+		return l.layout, err
+	}
+
+	l.ln(config.LineHeight)
+
+	// Text
+	for i := 0; i < len(content.text); i++ {
+		l.x = config.Margins
+		l.multiCell(0, config.LineHeight, content.text[i], "", "L", Warning{Section: "body", Line: content.textLines[i]})
+	}
+	if err := l.partError("body"); err != nil {
+		return l.layout, err
+	}
+
+	if signature := config.GetSignatureOrEmpty(); signature != "" {
+		if _, err := os.Stat(signature); err != nil {
+			return l.layout, &configError{Field: "Signature", Err: describeFileError(signature, err)}
+		}
+		width, height, err := metrics.imageSize(signature)
+		if err == nil {
+			err = metrics.err()
+		}
+		if err != nil {
+			return l.layout, partError(err, "Signature")
+		}
+		image := l.image(signature, width, height)
+		l.layout.Signature = &image
+		l.layout.SignaturePage = len(l.layout.Pages)
+	}
+	l.ln(config.LineHeight)
+	l.multiCell(0, config.LineHeight, config.GetSenderNameOrEmpty(), "", "L", Warning{Section: "config: SenderName"})
+	if err := l.partError("SenderName"); err != nil {
+		return l.layout, err
+	}
+
+	if len(config.Enclosures) > 0 {
+		names := MapStrings(config.Enclosures, enclosureName)
+		l.ln(config.LineHeight)
+		l.x = config.Margins
+		label := localize(resolveLocale(config.Locale), "letter.enclosures")
+		l.multiCell(0, config.LineHeight, label+" "+strings.Join(names, ", "), "", "L", Warning{Section: "config: Enclosures"})
+		if err := l.partError("Enclosures"); err != nil {
+			return l.layout, err
+		}
+	}
+	return l.layout, nil
+}
+
+// partError returns the error the metrics ran into while laying out a part of the letter, see partError
+func (l *layouter) partError(part string) error {
+	return partError(l.metrics.err(), part)
+}
+
+// partError attributes err to a part of the letter. Errors caused by a config field, e.g. a font file that cannot be
+// read, already name that field and are returned as they are.
+func partError(err error, part string) error {
+	if err == nil {
+		return nil
+	}
+	var fieldError *configError
+	if errors.As(err, &fieldError) {
+		return err
+	}
+	return fmt.Errorf("%s: %s", part, err)
+}
+
+func (l *layouter) setFont(style string, size float64) {
+	l.style = style
+	l.size = size
+}
+
+func (l *layouter) add(item layoutItem) {
+	page := &l.layout.Pages[len(l.layout.Pages)-1]
+	page.Items = append(page.Items, item)
+}
+
+// breakPage starts a new page if a cell of height h does not fit on the current one
+func (l *layouter) breakPage(h float64) {
+	if l.y+h > l.page.Height-l.page.Bottom {
+		l.layout.Pages = append(l.layout.Pages, layoutPage{})
+		l.y = l.page.Top
+	}
+}
+
+// ln moves to the start of the next line, h below the current one
+func (l *layouter) ln(h float64) {
+	l.x = l.config.Margins
+	l.y += h
+}
+
+// multiCell places text in lines of the given width, starting at the current position, and moves below it. Width 0
+// extends the lines to the right margin. For right to left letters the alignment is mirrored.
+func (l *layouter) multiCell(width, h float64, text, borderStr, alignStr string, location Warning) {
+	if l.rightToLeft {
+		alignStr = strings.NewReplacer("L", "R", "R", "L").Replace(alignStr)
+	}
+	bidi := l.rightToLeft || containsRightToLeft(text)
+	if bidi {
+		text = shapeArabic(text)
+	}
+	runs, missing := l.fonts.split(text)
+	l.checkConversion(runs, location)
+	if len(missing) > 0 && !Contains(coreFonts, l.fonts.primary()) {
+		// Characters missing from a core font are already reported by checkConversion
+		location.Message = fmt.Sprintf("no font in %v can render the characters %q", l.fonts.families, string(missing))
+		l.layout.Warnings = append(l.layout.Warnings, location)
+	}
+	x := l.x
+	if width == 0 {
+		width = l.page.Width - l.config.Margins - x
+	}
+	// Lines have to be wrapped in logical order before each one can be reordered
+	lines := l.wrap(text, width-2*l.page.CellMargin)
+	for i, line := range lines {
+		border := ""
+		if i == len(lines)-1 {
+			border = borderStr
+		}
+		if bidi {
+			line = reorderBidi(line, l.rightToLeft)
+		}
+		l.line(x, width, h, line, border, alignStr)
+	}
+	l.x = l.config.Margins
+}
+
+// checkConversion compares each run that has to be converted to the code page of a core font with its translation
+// and warns about the characters that got lost
+func (l *layouter) checkConversion(runs []fontRun, location Warning) {
+	for _, run := range runs {
+		if !Contains(coreFonts, run.family) {
+			continue
+		}
+		lost := lostCharacters(run.text, l.fonts.translators[run.family](run.text))
+		if len(lost) > 0 {
+			location.Message = fmt.Sprintf("the characters %q are lost in the conversion to cp1252 required by font %s", string(lost), run.family)
+			l.layout.Warnings = append(l.layout.Warnings, location)
+		}
+	}
+}
+
+// runWidths measures every character of run, each character is only measured once per font
+func (l *layouter) runWidths(run fontRun) []float64 {
+	var widths []float64
+	for _, r := range run.text {
+		key := fmt.Sprintf("%s/%s/%g/%c", run.family, l.style, l.size, r)
+		width, ok := l.widths[key]
+		if !ok {
+			width = l.metrics.stringWidth(run.family, l.style, l.size, string(r))
+			l.widths[key] = width
+		}
+		widths = append(widths, width)
+	}
+	return widths
+}
+
+// wrap breaks text into lines no wider than width, preferably at spaces
+func (l *layouter) wrap(text string, width float64) []string {
+	var lines []string
+	var line []rune
+	var widths []float64
+	lineWidth := 0.0
+	lastSpace := -1
+	runs, _ := l.fonts.split(text)
+	for _, run := range runs {
+		runWidths := l.runWidths(run)
+		for i, r := range []rune(run.text) {
+			line = append(line, r)
+			widths = append(widths, runWidths[i])
+			lineWidth += runWidths[i]
+			// like fpdf's MultiCell, lines are not broken at other whitespace such as no-break spaces
+			if r == ' ' {
+				lastSpace = len(line) - 1
+			}
+			if lineWidth > width && len(line) > 1 {
+				breakAt, restAt := len(line)-1, len(line)-1
+				if lastSpace >= 0 {
+					breakAt, restAt = lastSpace, lastSpace+1
+				}
+				lines = append(lines, string(line[:breakAt]))
+				line = append([]rune{}, line[restAt:]...)
+				widths = append([]float64{}, widths[restAt:]...)
+				lineWidth = 0
+				for _, remaining := range widths {
+					lineWidth += remaining
+				}
+				lastSpace = -1
+			}
+		}
+	}
+	return append(lines, string(line))
+}
+
+// line places a single line of text, split into runs of different fonts, and moves to the next line
+func (l *layouter) line(x, width, h float64, text, border, align string) {
+	runs, _ := l.fonts.split(text)
+	runWidths := make([]float64, len(runs))
+	total := 0.0
+	for i, run := range runs {
+		for _, width := range l.runWidths(run) {
+			runWidths[i] += width
+		}
+		total += runWidths[i]
+	}
+	l.breakPage(h)
+	l.cellBorder(x, width, h, border)
+	var start float64
+	switch align {
+	case "R":
+		start = x + width - l.page.CellMargin - total
+	case "C":
+		start = x + (width-total)/2
+	default:
+		start = x + l.page.CellMargin
+	}
+	item := layoutText{Y: l.y, Height: h, Style: l.style, Size: l.size}
+	for i, run := range runs {
+		item.Runs = append(item.Runs, layoutRun{X: start, Width: runWidths[i], Family: run.family, Text: run.text})
+		start += runWidths[i]
+	}
+	if len(item.Runs) > 0 {
+		l.add(item)
+	}
+	l.y += h
+}
+
+// cellBorder adds the rules framing a cell at the current height, border is "1" for all sides or a combination of
+// "L", "T", "R" and "B"
+func (l *layouter) cellBorder(x, width, h float64, border string) {
+	if border == "1" {
+		border = "LTRB"
+	}
+	y := l.y
+	sides := map[byte]layoutRule{
+		'L': {x, y, x, y + h},
+		'T': {x, y, x + width, y},
+		'R': {x + width, y, x + width, y + h},
+		'B': {x, y + h, x + width, y + h},
+	}
+	for _, side := range []byte("LTRB") {
+		if strings.IndexByte(border, side) >= 0 {
+			l.add(sides[side])
+		}
+	}
+}
+
+// image places an image of the given size at the current height and moves below it. The image is left aligned,
+// or right aligned for right to left letters.
+func (l *layouter) image(file string, width, height float64) layoutImage {
+	l.breakPage(height)
+	x := l.x
+	if l.rightToLeft {
+		x = l.page.Width - l.config.Margins - width
+	}
+	image := layoutImage{File: file, X: x, Y: l.y, Width: width, Height: height}
+	l.add(image)
+	l.y += height
+	return image
+}
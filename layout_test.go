@@ -0,0 +1,159 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fixedMetrics makes every character 2mm wide and every image 40mm by 10mm
+type fixedMetrics struct{}
+
+func (fixedMetrics) stringWidth(_ string, _ string, _ float64, text string) float64 {
+	return float64(len([]rune(text))) * 2
+}
+
+func (fixedMetrics) imageSize(string) (float64, float64, error) {
+	return 40, 10, nil
+}
+
+func (fixedMetrics) err() error {
+	return nil
+}
+
+// failingMetrics reports an error, e.g. a font file that could not be loaded, once it is asked for sizes
+type failingMetrics struct {
+	fixedMetrics
+	failure error
+}
+
+func (m failingMetrics) err() error {
+	return m.failure
+}
+
+var testGeometry = pageGeometry{Width: 210, Height: 297, Top: 20, Bottom: 20, CellMargin: 1}
+
+func testFontChain() fontChain {
+	return fontChain{
+		families:    []string{"test"},
+		coverage:    map[string]func(r rune) bool{"test": func(rune) bool { return true }},
+		translators: map[string]func(string) string{"test": func(s string) string { return s }},
+	}
+}
+
+func testLetter(configure func(config *Config), text ...string) letter {
+	config := defaultConfig
+	config.Sender = []string{"A", "B"}
+	config.Date = "01.06.2023"
+	configure(&config)
+	content := letter{config: config, recipient: []string{"Bob"}, recipientLines: []int{5}, subject: "Hi", subjectLine: 7}
+	for i, line := range text {
+		content.text = append(content.text, line)
+		content.textLines = append(content.textLines, 9+i)
+	}
+	return content
+}
+
+func text(y, h float64, style string, size float64, x float64, s string) layoutText {
+	return layoutText{Y: y, Height: h, Style: style, Size: size, Runs: []layoutRun{{X: x, Width: float64(len([]rune(s))) * 2, Family: "test", Text: s}}}
+}
+
+func TestLayoutLetter(t *testing.T) {
+	signature := "./test/it/pdf/Signature.jpg"
+	senderName := "Me"
+	content := testLetter(func(config *Config) {
+		config.Signature = &signature
+		config.SenderName = &senderName
+	}, "Body")
+	layout, err := layoutLetter(content, testGeometry, testFontChain(), fixedMetrics{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := layoutImage{File: signature, X: 25, Y: 132, Width: 40, Height: 10}
+	want := []layoutItem{
+		layoutRule{X1: 25, Y1: 56, X2: 95, Y2: 56},
+		text(50, 6, "", 7, 26, "A, B"),
+		text(56, 6, "", 10, 26, "Bob"),
+		// right aligned within the margins
+		text(100, 8, "", 12, 164, "01.06.2023"),
+		text(108, 8, "B", 12, 26, "Hi"),
+		text(124, 8, "", 12, 26, "Body"),
+		image,
+		text(150, 8, "", 12, 26, "Me"),
+	}
+	AssertEquals(t, len(layout.Pages), 1, "pages")
+	if !reflect.DeepEqual(layout.Pages[0].Items, want) {
+		t.Errorf("got items %+v, wanted %+v", layout.Pages[0].Items, want)
+	}
+	AssertEquals(t, *layout.Signature, image, "signature")
+	AssertEquals(t, layout.SignaturePage, 1, "signature page")
+}
+
+func TestLayoutPageBreak(t *testing.T) {
+	var body []string
+	for i := 0; i < 40; i++ {
+		body = append(body, fmt.Sprintf("line %d", i))
+	}
+	layout, err := layoutLetter(testLetter(func(*Config) {}, body...), testGeometry, testFontChain(), fixedMetrics{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, len(layout.Pages), 2, "pages")
+	// the first page ends where a further line would enter the bottom margin
+	first := layout.Pages[0].Items
+	AssertEquals(t, first[len(first)-1].(layoutText).Y, 268.0, "last line on the first page")
+	if top := layout.Pages[1].Items[0]; !reflect.DeepEqual(top, text(20, 8, "", 12, 26, "line 19")) {
+		t.Errorf("got %+v as first item of the second page", top)
+	}
+}
+
+func TestLayoutRightToLeft(t *testing.T) {
+	layout, err := layoutLetter(testLetter(func(config *Config) { config.Direction = "rtl" }), testGeometry, testFontChain(), fixedMetrics{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := layout.Pages[0].Items
+	// the address section is mirrored and its text right aligned
+	AssertEquals(t, items[0], layoutItem(layoutRule{X1: 115, Y1: 56, X2: 185, Y2: 56}), "rule")
+	AssertEquals(t, items[1].(layoutText).Runs[0].X, 176.0, "sender")
+	AssertEquals(t, items[3].(layoutText).Runs[0].X, 26.0, "date")
+}
+
+func TestLayoutErrors(t *testing.T) {
+	content := testLetter(func(*Config) {})
+	_, err := layoutLetter(content, testGeometry, testFontChain(), failingMetrics{failure: errors.New("embedded font x: not found")}, time.Now())
+	AssertEquals(t, err.Error(), "Sender: embedded font x: not found", "error attributed to the part")
+	fieldError := &configError{Field: "FontImport.FontFileNameItalic", Err: os.ErrNotExist}
+	_, err = layoutLetter(content, testGeometry, testFontChain(), failingMetrics{failure: fieldError}, time.Now())
+	AssertEquals(t, err.Error(), "FontImport.FontFileNameItalic: file does not exist", "error naming a config field")
+}
+
+func TestWrap(t *testing.T) {
+	l := &layouter{fonts: testFontChain(), metrics: fixedMetrics{}, widths: map[string]float64{}}
+	AssertStringSliceEquals(t, l.wrap("aa bb cc", 11), []string{"aa bb", "cc"}, "break at spaces")
+	// like in fpdf, a no-break space is no break opportunity, the words it joins are split like a single long word
+	AssertStringSliceEquals(t, l.wrap("aa\u00a0bb cc", 7), []string{"aa\u00a0", "bb", "cc"}, "words joined by a no-break space")
+	AssertStringSliceEquals(t, l.wrap("aaaaaa", 7), []string{"aaa", "aaa"}, "words wider than the line")
+}
@@ -0,0 +1,127 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var sectionSeparationRegex = regexp.MustCompile("^//.*")
+
+type LetterSection int
+
+const (
+	Initial       LetterSection = iota
+	Configuration LetterSection = iota
+	Address       LetterSection = iota
+	Subject       LetterSection = iota
+	Body          LetterSection = iota
+)
+
+// letter is the content of a .left file. The line numbers of the address, subject and body lines refer to the file
+// and locate warnings.
+type letter struct {
+	config         Config
+	recipient      []string
+	recipientLines []int
+	subject        string
+	subjectLine    int
+	text           []string
+	textLines      []int
+}
+
+// parseLetter reads the sections of inputFile and merges its config section into defaultConfig
+func parseLetter(inputFile string, defaultConfig Config) (letter, error) {
+	var content letter
+	var configJson string
+	var bodyReached = false
+	var multiLineSubject = false
+	file, jsonReadError := os.Open(inputFile)
+	if jsonReadError != nil {
+		return content, jsonReadError
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	sectionIndex := 0
+	lineNumber := 0
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNumber++
+		if sectionSeparationRegex.MatchString(line) {
+			sectionIndex++
+			if LetterSection(sectionIndex) == Body {
+				bodyReached = true
+			}
+			continue
+		}
+		switch LetterSection(sectionIndex) {
+		case Initial:
+			// Tolerate freestyle text before the config section
+			continue
+		case Configuration:
+			configJson = configJson + line
+		case Subject:
+			if content.subject != "" {
+				/*
+				 Don't error out just yet in order to produce meaningful error messages. If we end up finding
+				 the correct count of config sections we will complain about the multiline subject.
+				 If however we detect missing config sections the multiline subject is just a symptom and we should
+				 really complain about missing sections.
+				*/
+				multiLineSubject = true
+			}
+			content.subject = line
+			content.subjectLine = lineNumber
+		case Address:
+			content.recipient = append(content.recipient, line)
+			content.recipientLines = append(content.recipientLines, lineNumber)
+		case Body:
+			fallthrough // tolerate config separator in body
+		default:
+			content.text = append(content.text, scanner.Text())
+			content.textLines = append(content.textLines, lineNumber)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return content, err
+	}
+	if !bodyReached {
+		return content, errors.New("letters MUST have exactly four sections: config, address, subject, body (in this order), initiated by lines starting with //")
+	} else if multiLineSubject {
+		return content, errors.New("the subject section (third section) must only contain one single line")
+	}
+	content.config = defaultConfig
+	jsonParseError := mergeConfigJson([]byte(configJson), &content.config)
+	if jsonParseError != nil {
+		return content, jsonParseError
+	}
+
+	if !Contains([]string{"", "ltr", "rtl"}, strings.ToLower(content.config.Direction)) {
+		return content, &configError{Field: "Direction", Err: fmt.Errorf("%q is neither \"ltr\" nor \"rtl\"", content.config.Direction)}
+	}
+	return content, nil
+}
@@ -0,0 +1,70 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// minimalLetter is the smallest complete letter, letterWithConfig fills in its config section
+const minimalLetter = "// config\n{}\n// address\nName\n// subject\nSubject\n// body\nBody\n"
+
+// letterWithConfig returns minimalLetter with the given json as its config section
+func letterWithConfig(config string) string {
+	return strings.Replace(minimalLetter, "{}", config, 1)
+}
+
+// sampleLetter uses most of what shows on the page: a sender, an address with several lines, a date with a prefix, a
+// subject and body with characters that need escaping in markup, blank lines and a signature
+const sampleLetter = "// config\n{\"Sender\": [\"Me\", \"Here\"], \"SenderName\": \"Me & Co\", \"Date\": \"01.06.2023\", " +
+	"\"DatePrefix\": \"Here, \", \"Signature\": \"./test/it/pdf/Signature.jpg\", \"Locale\": \"de\", \"AddressSectionY\": 45.5}\n" +
+	"// address\nYou\nThere\n// subject\nAbout <b>tags</b>\n// body\nDear you,\n\nbody  text\n"
+
+// rightToLeftLetter is a right to left letter with an enclosure
+var rightToLeftLetter = letterWithConfig(`{"Direction": "rtl", "Enclosures": ["a/invoice.pdf"], "Locale": "en"}`)
+
+// writeTestLetter writes letter to letter.left in a temporary directory and returns the path of that file
+func writeTestLetter(t *testing.T, letter string) string {
+	inputFile := filepath.Join(t.TempDir(), "letter.left")
+	if err := os.WriteFile(inputFile, []byte(letter), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return inputFile
+}
+
+// renderTestLetter writes letter like writeTestLetter and renders it in one of the formats. It returns the path of the
+// letter file, the output is next to it.
+func renderTestLetter(t *testing.T, letter string, format string, options Options) (string, error) {
+	inputFile := writeTestLetter(t, letter)
+	_, err := formats[format](inputFile, defaultConfig, options)
+	return inputFile, err
+}
+
+// readTestOutput returns the file inputFile was rendered to in format
+func readTestOutput(t *testing.T, inputFile string, format string) []byte {
+	data, err := os.ReadFile(formatFileName(inputFile, format))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -25,6 +25,7 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"strings"
 )
 
 func abort(message string, invocationError bool) {
@@ -36,44 +37,93 @@ func abort(message string, invocationError bool) {
 }
 
 func printError(message string) {
-	_, err := fmt.Fprintf(os.Stderr, "Error: %s\n", message)
+	_, err := fmt.Fprintf(os.Stderr, "%s: %s\n", localize(cliLocale, "error.prefix"), message)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func printWarning(message string) {
+	_, err := fmt.Fprintf(os.Stderr, "%s: %s\n", localize(cliLocale, "warning.prefix"), message)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 }
 
 func printUsage() {
-	fmt.Println("left - generates letter from txt file")
+	fmt.Println(localize(cliLocale, "usage.title"))
 	fmt.Println("")
-	fmt.Println("Usage: left OPTIONS | FILE")
+	fmt.Println(localize(cliLocale, "usage.synopsis"))
 	fmt.Println("")
-	fmt.Println("If a FILE argument is provided the file is used as an input.txt to generate a PDF formatted letter.")
-	fmt.Println("The text file is expected to consist of two sections, delimited by a line that only contains three")
-	fmt.Println("equal signs. (===)")
-	fmt.Println("The first section contains the letter configuration, formatted in json (Also see OPTIONS).")
-	fmt.Println("The second section contains the letter content.")
+	fmt.Println(localize(cliLocale, "usage.description"))
 	fmt.Println("")
-	fmt.Println("Otherwise the following OPTIONS are available:")
+	fmt.Println(localize(cliLocale, "usage.options"))
 	fmt.Println("  -help")
-	fmt.Println("        Prints this help")
+	fmt.Println("        " + localize(cliLocale, "usage.help"))
 	flag.PrintDefaults()
 }
 
-func Run(pathsToRead []string, dumpConfig *bool, create *bool, remainingArgs []string) {
+// Options holds the command line flags that control what Run does
+type Options struct {
+	DumpConfig bool
+	Create     bool
+	ListFonts  bool
+	// Strict turns warnings into errors
+	Strict bool
+	// Stats prints the size of the rendered pdf and of the fonts in it
+	Stats bool
+	// PDFA overrides the PDFA config field if not empty
+	PDFA string
+	// EmbedSource embeds the letter in the pdf regardless of the EmbedSource config field
+	EmbedSource bool
+	// Extract prints the letter embedded in the given pdf instead of rendering
+	Extract bool
+	// SignKey is a PKCS#12 keystore or a PEM private key to sign the pdf with, SignCert the matching PEM certificate
+	SignKey  string
+	SignCert string
+	// UserPasswordFile, OwnerPasswordFile and Permissions override the fields of the Protection config field
+	UserPasswordFile  string
+	OwnerPasswordFile string
+	// Permissions is a comma separated list or "none"
+	Permissions string
+	// Reproducible overrides the Reproducible config field if set
+	Reproducible bool
+	// Format is the output format, one of formats, pdf if empty
+	Format string
+	// DPI is the resolution of -format png, defaultDPI if 0
+	DPI float64
+	// Watch renders the letter again whenever it or a file it depends on changes
+	Watch bool
+	// ConfigFile is the -config file, Watch looks up the config files again with it to find new project config files
+	ConfigFile string
+}
+
+func Run(pathsToRead []string, options Options, remainingArgs []string) {
 	loadedDefaultConfig, err := loadDefaultConfig(pathsToRead)
 	if err != nil {
 		abort(err.Error(), false)
 	}
-	if *dumpConfig && *create {
-		abort("flags -dump-config and -create are mutually exclusive!", true)
-	} else if *create && len(remainingArgs) > 0 {
-		abort("flag -create is incompatible with positional arguments!", true)
-	} else if *create {
+	if options.DumpConfig && options.Create {
+		abort(localize(cliLocale, "error.exclusive", "-dump-config", "-create"), true)
+	} else if options.Create && len(remainingArgs) > 0 {
+		abort(localize(cliLocale, "error.positional", "-create"), true)
+	} else if options.Create {
 		emptyLetter, err := createEmptyLetter(loadedDefaultConfig)
 		if err == nil {
 			fmt.Println(emptyLetter)
 		}
-	} else if *dumpConfig {
+	} else if options.Extract {
+		if len(remainingArgs) == 0 {
+			abort(localize(cliLocale, "error.missingArgs"), true)
+		}
+		var source []byte
+		source, err = extractSource(remainingArgs[0])
+		if err == nil {
+			_, err = os.Stdout.Write(source)
+		}
+	} else if options.ListFonts {
+		fmt.Print(printFontFamilies(groupFontFamilies(scanFonts(systemFontDirs(runtime.GOOS)))))
+	} else if options.DumpConfig {
 		configDump, err := printConfiguration(loadedDefaultConfig)
 		if err == nil {
 			fmt.Println(configDump)
@@ -81,10 +131,37 @@ func Run(pathsToRead []string, dumpConfig *bool, create *bool, remainingArgs []s
 	} else {
 		// Consume all the flags that were parsed as flags.
 		if len(remainingArgs) == 0 {
-			abort("Missing arguments.", true)
+			abort(localize(cliLocale, "error.missingArgs"), true)
+		}
+		format := options.Format
+		if format == "" {
+			format = "pdf"
+		}
+		renderFormat, found := formats[format]
+		if !found {
+			abort(localize(cliLocale, "error.format", format, strings.Join(formatNames(), ", ")), true)
+		} else if options.Stats && format != "pdf" {
+			abort(localize(cliLocale, "error.exclusive", "-stats", "-format "+format), true)
+		} else if options.Stats && options.Watch {
+			abort(localize(cliLocale, "error.exclusive", "-stats", "-watch"), true)
 		}
 		inputFile := remainingArgs[0]
-		err = render(inputFile, loadedDefaultConfig)
+		if options.Watch {
+			watchLetter(inputFile, func() []string {
+				return GetConfigFilePaths(runtime.GOOS, options.ConfigFile, inputFile)
+			}, options, renderFormat)
+			return
+		}
+		var warnings []Warning
+		warnings, err = renderFormat(inputFile, loadedDefaultConfig, options)
+		for _, warning := range warnings {
+			printWarning(warning.String())
+		}
+		if err == nil && options.Stats {
+			var stats string
+			stats, err = reportStats(outputFileName(inputFile))
+			fmt.Print(stats)
+		}
 	}
 	if err != nil {
 		abort(err.Error(), false)
@@ -93,20 +170,46 @@ func Run(pathsToRead []string, dumpConfig *bool, create *bool, remainingArgs []s
 
 func main() {
 	flag.Usage = printUsage
-	version := flag.Bool("version", false, "ignore all other arguments, print the left version and exit")
-	dumpConfig := flag.Bool("dump-config", false, "dumps the standard config to stdout")
-	customConfig := flag.String("config", "", "custom config file to read from after loading configuration defaults")
-	create := flag.Bool("create", false, "prints a template for a new letter to stdout")
+	version := flag.Bool("version", false, localize(cliLocale, "flag.version"))
+	dumpConfig := flag.Bool("dump-config", false, localize(cliLocale, "flag.dumpConfig"))
+	customConfig := flag.String("config", "", localize(cliLocale, "flag.config"))
+	create := flag.Bool("create", false, localize(cliLocale, "flag.create"))
+	listFonts := flag.Bool("list-fonts", false, localize(cliLocale, "flag.listFonts"))
+	strict := flag.Bool("strict", false, localize(cliLocale, "flag.strict"))
+	stats := flag.Bool("stats", false, localize(cliLocale, "flag.stats"))
+	pdfa := flag.String("pdfa", "", localize(cliLocale, "flag.pdfa"))
+	embedSource := flag.Bool("embed-source", false, localize(cliLocale, "flag.embedSource"))
+	extract := flag.Bool("extract", false, localize(cliLocale, "flag.extract"))
+	signKey := flag.String("sign-key", "", localize(cliLocale, "flag.signKey"))
+	signCert := flag.String("sign-cert", "", localize(cliLocale, "flag.signCert"))
+	userPasswordFile := flag.String("user-password-file", "", localize(cliLocale, "flag.userPasswordFile"))
+	ownerPasswordFile := flag.String("owner-password-file", "", localize(cliLocale, "flag.ownerPasswordFile"))
+	permissions := flag.String("permissions", "", localize(cliLocale, "flag.permissions"))
+	reproducible := flag.Bool("reproducible", false, localize(cliLocale, "flag.reproducible"))
+	format := flag.String("format", "pdf", localize(cliLocale, "flag.format"))
+	dpi := flag.Float64("dpi", defaultDPI, localize(cliLocale, "flag.dpi"))
+	watch := flag.Bool("watch", false, localize(cliLocale, "flag.watch"))
 
 	flag.Parse()
 
+	// -dpi is checked here and not in Run, where a DPI of 0 stands for the default
+	if *dpi <= 0 {
+		abort(localize(cliLocale, "error.dpi"), true)
+	}
 	if *version {
 		fmt.Println(Version())
 		os.Exit(0)
 	}
 	remainingArgs := os.Args[len(os.Args)-flag.NArg():]
 
-	configPathsToRead := GetConfigFilePaths(runtime.GOOS, *customConfig)
+	letterFile := ""
+	if len(remainingArgs) > 0 {
+		letterFile = remainingArgs[0]
+	}
+	configPathsToRead := GetConfigFilePaths(runtime.GOOS, *customConfig, letterFile)
 
-	Run(configPathsToRead, dumpConfig, create, remainingArgs)
+	Run(configPathsToRead, Options{DumpConfig: *dumpConfig, Create: *create, ListFonts: *listFonts, Strict: *strict, Stats: *stats, PDFA: *pdfa,
+		EmbedSource: *embedSource, Extract: *extract, SignKey: *signKey, SignCert: *signCert,
+		UserPasswordFile: *userPasswordFile, OwnerPasswordFile: *ownerPasswordFile, Permissions: *permissions,
+		Reproducible: *reproducible, Format: *format, DPI: *dpi, Watch: *watch, ConfigFile: *customConfig}, remainingArgs)
 }
@@ -0,0 +1,216 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// pageLetter is a letter laid out for the formats that draw its pages themselves instead of with fpdf
+type pageLetter struct {
+	title         string
+	width, height float64
+	layout        letterLayout
+	glyphs        *glyphOutliner
+}
+
+// layoutPages lays out a letter for drawing its pages. Text is measured with fpdf, so that the pages look like the
+// ones of the pdf. Enclosures are not part of the letter's own pages.
+func layoutPages(inputFile string, defaultConfig Config, options Options) (pageLetter, error) {
+	content, err := parseLetter(inputFile, defaultConfig)
+	if err != nil {
+		return pageLetter{}, err
+	}
+	config := content.config
+	// PDF/A would rule out the core fonts, which are substituted here anyway
+	config.PDFA = ""
+	now, _, err := currentTime()
+	if err != nil {
+		return pageLetter{}, err
+	}
+	pdf := fpdf.New("P", "mm", "A4", "")
+	fonts, err := newFontChain(pdf, config)
+	if err != nil {
+		return pageLetter{}, err
+	}
+	backend := &pdfBackend{pdf: pdf, fonts: fonts, registered: map[string]bool{}}
+	pdf.SetMargins(config.Margins, 20, config.Margins)
+	page := backend.geometry()
+	layout, err := layoutLetter(content, page, fonts, backend, now)
+	if err != nil {
+		return pageLetter{}, err
+	}
+	if options.Strict && len(layout.Warnings) > 0 {
+		return pageLetter{}, strictError(inputFile, layout.Warnings)
+	}
+	// fpdf's A4 is 595.28pt wide, which is 210.0015mm
+	return pageLetter{
+		title:  config.documentTitle(content.subject),
+		width:  math.Round(page.Width*10) / 10,
+		height: math.Round(page.Height*10) / 10,
+		layout: layout,
+		glyphs: &glyphOutliner{fonts: fonts, parsed: map[string]*sfnt.Font{}},
+	}, nil
+}
+
+// pageFileName returns the name of the file a page of a letter is written to, counting pages from 1. The first page
+// is named like the letter, the others have their number appended, e.g. letter.png, letter-2.png.
+func pageFileName(inputFile string, format string, page int) string {
+	if page == 1 {
+		return formatFileName(inputFile, format)
+	}
+	return strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + "-" + strconv.Itoa(page) + "." + format
+}
+
+// removeStalePages removes the page files left from an earlier rendering of the letter that had more than pages pages,
+// so that they are not taken for part of the letter
+func removeStalePages(inputFile string, format string, pages int) error {
+	for page := pages + 1; ; page++ {
+		err := os.Remove(pageFileName(inputFile, format, page))
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// pathSegment is a segment of an outline in mm. MoveTo and LineTo have one point, QuadTo two and CubeTo three.
+type pathSegment struct {
+	Op     sfnt.SegmentOp
+	Points [][2]float64
+}
+
+// segmentPoints is the number of points of each kind of segment
+var segmentPoints = map[sfnt.SegmentOp]int{sfnt.SegmentOpMoveTo: 1, sfnt.SegmentOpLineTo: 1, sfnt.SegmentOpQuadTo: 2, sfnt.SegmentOpCubeTo: 3}
+
+// coreFontSubstitutes are the embedded fonts the core fonts are drawn with, as their font files are not shipped with
+// left. Core fonts without an entry are drawn with DejaVu Sans Condensed.
+var coreFontSubstitutes = map[string]string{
+	"times": "freeserif",
+}
+
+// glyphOutliner turns text into the outlines of its glyphs
+type glyphOutliner struct {
+	fonts  fontChain
+	parsed map[string]*sfnt.Font
+	buffer sfnt.Buffer
+}
+
+// font returns a style of a font of the chain
+func (g *glyphOutliner) font(family string, style string) (*sfnt.Font, error) {
+	key := family + "/" + style
+	if parsed, found := g.parsed[key]; found {
+		return parsed, nil
+	}
+	face := g.fonts.faces[family]
+	if face == nil {
+		substitute, found := coreFontSubstitutes[family]
+		if !found {
+			substitute = "dejavusanscondensed"
+		}
+		face = func(style string) ([]byte, error) {
+			return embeddedFontFile(substitute, style)
+		}
+	}
+	data, err := face(style)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	g.parsed[key] = parsed
+	return parsed, nil
+}
+
+// outline returns the outlines of the glyphs of a run, placed on the baseline fpdf puts the text of a cell on
+func (g *glyphOutliner) outline(text layoutText, run layoutRun) ([]pathSegment, error) {
+	f, err := g.font(run.Family, text.Style)
+	if err != nil {
+		return nil, err
+	}
+	// glyphs are loaded at one pixel per font unit and scaled from there, to keep their precision
+	unitsPerEm := f.UnitsPerEm()
+	ppem := fixed.I(int(unitsPerEm))
+	size := text.Size * 25.4 / 72
+	scale := size / float64(unitsPerEm) / 64
+	x := run.X
+	baseline := text.Y + text.Height/2 + 0.3*size
+	var path []pathSegment
+	for _, r := range run.Text {
+		// like fpdf, characters beyond the basic multilingual plane are left out
+		if r > 0xFFFF {
+			continue
+		}
+		index, err := f.GlyphIndex(&g.buffer, r)
+		if err != nil {
+			return nil, err
+		}
+		segments, err := f.LoadGlyph(&g.buffer, index, ppem, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range segments {
+			points := make([][2]float64, segmentPoints[segment.Op])
+			for i := range points {
+				points[i] = [2]float64{x + float64(segment.Args[i].X)*scale, baseline + float64(segment.Args[i].Y)*scale}
+			}
+			path = append(path, pathSegment{Op: segment.Op, Points: points})
+		}
+		advance, err := f.GlyphAdvance(&g.buffer, index, ppem, font.HintingNone)
+		if err != nil {
+			return nil, err
+		}
+		x += float64(advance) * scale
+	}
+	return path, nil
+}
+
+// ruleWidth is the width of rules in mm, the default line width of fpdf
+const ruleWidth = 0.2
+
+// rulePath returns the outline of a rule with butt caps
+func rulePath(rule layoutRule) []pathSegment {
+	dx, dy := rule.X2-rule.X1, rule.Y2-rule.Y1
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return nil
+	}
+	nx, ny := -dy/length*ruleWidth/2, dx/length*ruleWidth/2
+	corners := [][2]float64{{rule.X1 + nx, rule.Y1 + ny}, {rule.X2 + nx, rule.Y2 + ny}, {rule.X2 - nx, rule.Y2 - ny}, {rule.X1 - nx, rule.Y1 - ny}}
+	return []pathSegment{
+		{Op: sfnt.SegmentOpMoveTo, Points: corners[0:1]},
+		{Op: sfnt.SegmentOpLineTo, Points: corners[1:2]},
+		{Op: sfnt.SegmentOpLineTo, Points: corners[2:3]},
+		{Op: sfnt.SegmentOpLineTo, Points: corners[3:4]},
+		{Op: sfnt.SegmentOpLineTo, Points: corners[0:1]},
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+)
+
+// darkPixels counts the pixels within a rectangle given in mm that are not white
+func darkPixels(page image.Image, dpi float64, x1, y1, x2, y2 float64) int {
+	pixels := func(mm float64) int { return int(mm * dpi / 25.4) }
+	count := 0
+	for y := pixels(y1); y < pixels(y2); y++ {
+		for x := pixels(x1); x < pixels(x2); x++ {
+			if r, g, b, _ := page.At(x, y).RGBA(); r < 0x8000 || g < 0x8000 || b < 0x8000 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func TestRenderPng(t *testing.T) {
+	inputFile, err := renderTestLetter(t, sampleLetter, "png", Options{DPI: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(pageFileName(inputFile, "png", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	page, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, page.Bounds(), image.Rect(0, 0, 413, 585), "size at 50 dpi")
+	AssertEquals(t, darkPixels(page, 50, 0, 0, 210, 40), 0, "dark pixels above the address section")
+	if darkPixels(page, 50, 25, 50, 95, 80) == 0 {
+		t.Error("the address section is empty")
+	}
+	if darkPixels(page, 50, 150, 95, 185, 105) == 0 {
+		t.Error("the date is missing")
+	}
+	// the signature is the only thing in color
+	colored := false
+	for y := page.Bounds().Min.Y; y < page.Bounds().Max.Y && !colored; y++ {
+		for x := page.Bounds().Min.X; x < page.Bounds().Max.X && !colored; x++ {
+			r, _, b, _ := page.At(x, y).RGBA()
+			colored = b > r+0x4000
+		}
+	}
+	if !colored {
+		t.Error("the signature is missing")
+	}
+	if _, err = os.Stat(pageFileName(inputFile, "png", 2)); err == nil {
+		t.Error("a one page letter has one image")
+	}
+}
+
+func TestRenderPngOffPage(t *testing.T) {
+	letter := "// config\n{\"Sender\": [\"Me\"], \"AddressSectionX\": -20}\n" +
+		"// address\nName at the very left edge\n// subject\nSubject\n// body\nBody\n"
+	inputFile, err := renderTestLetter(t, letter, "png", Options{DPI: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := png.Decode(bytes.NewReader(readTestOutput(t, inputFile, "png")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if darkPixels(page, 50, 0, 40, 10, 80) == 0 {
+		t.Error("the part of the address on the page is missing")
+	}
+	// what is cut off at the left edge must not show up at the right one
+	AssertEquals(t, darkPixels(page, 50, 200, 0, 210, 297), 0, "dark pixels at the right edge")
+}
+
+func TestRenderPngPages(t *testing.T) {
+	inputFile := writeTestLetter(t, "// config\n{}\n// address\nYou\n// subject\nLong\n// body\n"+strings.Repeat("line\n", 40))
+	if _, err := renderPng(inputFile, defaultConfig, Options{DPI: 10}); err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range []int{1, 2} {
+		if _, err := os.Stat(pageFileName(inputFile, "png", page)); err != nil {
+			t.Errorf("page %d: %v", page, err)
+		}
+	}
+	// the second page of the longer version must not stay around
+	if err := os.WriteFile(inputFile, []byte(minimalLetter), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renderPng(inputFile, defaultConfig, Options{DPI: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pageFileName(inputFile, "png", 2)); !os.IsNotExist(err) {
+		t.Errorf("the page of the longer letter was not removed: %v", err)
+	}
+	_, err := renderPng(inputFile, defaultConfig, Options{DPI: 1000})
+	AssertEquals(t, err.Error(), "at 1000 dpi a page has 8268x11693 pixels, more than the 40 million left draws, choose a lower -dpi",
+		"too many pixels")
+}
+
+func TestRenderSvg(t *testing.T) {
+	inputFile, err := renderTestLetter(t, sampleLetter, "svg", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := string(readTestOutput(t, inputFile, "svg"))
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="210mm" height="297mm" viewBox="0 0 210 297">`,
+		`<title>About &lt;b&gt;tags&lt;/b&gt;</title>`,
+		`<path aria-label="About &lt;b&gt;tags&lt;/b&gt;" d="M `,
+		`<path aria-label="Me &amp; Co" d="M `,
+		`<line x1="25" y1="51.5" x2="95" y2="51.5" stroke="#000" stroke-width="0.2"/>`,
+		`preserveAspectRatio="none" href="data:image/jpeg;base64,/9j/`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("the svg does not contain %s", want)
+		}
+	}
+}
+
+func TestPageFileName(t *testing.T) {
+	AssertEquals(t, pageFileName("a/letter.left", "png", 1), "a/letter.png", "first page")
+	AssertEquals(t, pageFileName("a/letter.left", "png", 2), "a/letter-2.png", "second page")
+}
+
+func TestSvgPathData(t *testing.T) {
+	AssertEquals(t, svgPathData(rulePath(layoutRule{X1: 10, Y1: 20, X2: 30, Y2: 20})),
+		"M 10 20.1 L 30 20.1 L 30 19.9 L 10 19.9 L 10 20.1", "rule")
+}
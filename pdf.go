@@ -20,212 +20,302 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"embed"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-pdf/fpdf"
-	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 )
 
-var sectionSeparationRegex = regexp.MustCompile("^//.*")
-
 //go:embed fonts/*
 var fontsDir embed.FS
 
-type LetterSection int
+func addEmbeddedFont(pdf *fpdf.Fpdf, family string, style string) {
+	data, err := embeddedFontFile(family, style)
+	if err != nil {
+		pdf.SetError(err)
+		return
+	}
+	pdf.AddUTF8FontFromBytes(family, style, data)
+}
 
-const (
-	Initial       LetterSection = iota
-	Configuration LetterSection = iota
-	Address       LetterSection = iota
-	Subject       LetterSection = iota
-	Body          LetterSection = iota
-)
+// embeddedFontFile returns the font file of a style of an embedded font, which has no italic faces
+func embeddedFontFile(family string, style string) ([]byte, error) {
+	face := "regular"
+	if strings.Contains(style, "B") {
+		face = "bold"
+	}
+	data, err := fontsDir.ReadFile(fmt.Sprintf("fonts/%s/%s.ttf", family, face))
+	if err != nil {
+		return nil, fmt.Errorf("embedded font %s: %s", family, err)
+	}
+	return data, nil
+}
 
-func addEmbeddedFont(pdf *fpdf.Fpdf, family string) {
-	regular, _ := fontsDir.ReadFile(fmt.Sprintf("fonts/%s/regular.ttf", family))
-	for _, style := range []string{"", "I"} {
-		pdf.AddUTF8FontFromBytes(family, style, regular)
+// addExternalFont registers a style of an imported font, load returns the font file for the style in a format fpdf
+// understands
+func addExternalFont(pdf *fpdf.Fpdf, family string, style string, load func(style string) ([]byte, error)) {
+	data, err := load(style)
+	if err != nil {
+		pdf.SetError(err)
+		return
 	}
-	bold, _ := fontsDir.ReadFile(fmt.Sprintf("fonts/%s/bold.ttf", family))
-	for _, style := range []string{"B", "BI"} {
-		pdf.AddUTF8FontFromBytes(family, style, bold)
+	pdf.AddUTF8FontFromBytes(family, style, data)
+}
+
+// pdfBackend measures and draws letter layouts with fpdf
+type pdfBackend struct {
+	pdf        *fpdf.Fpdf
+	fonts      fontChain
+	registered map[string]bool
+}
+
+// signatureImageOptions are used for signatures, which are jpeg images
+var signatureImageOptions = fpdf.ImageOptions{ImageType: "jpg"}
+
+// useFont switches to a font. Fonts are only registered with fpdf once they are actually used, as every registered
+// font ends up in the pdf.
+func (b *pdfBackend) useFont(family string, style string, size float64) {
+	key := family + "/" + style
+	if !b.registered[key] {
+		if register := b.fonts.register[family]; register != nil {
+			register(style)
+		}
+		b.registered[key] = true
+	}
+	b.pdf.SetFont(family, style, size)
+}
+
+func (b *pdfBackend) stringWidth(family string, style string, size float64, text string) float64 {
+	b.useFont(family, style, size)
+	return b.pdf.GetStringWidth(b.fonts.translators[family](text))
+}
+
+func (b *pdfBackend) imageSize(file string) (float64, float64, error) {
+	info := b.pdf.RegisterImageOptions(file, signatureImageOptions)
+	if err := b.pdf.Error(); err != nil {
+		return 0, 0, err
 	}
+	// Images without explicit size are placed at 96 dpi, whereas their info assumes 72 dpi
+	return info.Width() * 72 / 96, info.Height() * 72 / 96, nil
+}
+
+func (b *pdfBackend) err() error {
+	return b.pdf.Error()
+}
+
+// geometry returns the page format of the pdf, its margins have to be set before
+func (b *pdfBackend) geometry() pageGeometry {
+	width, height := b.pdf.GetPageSize()
+	_, top, _, _ := b.pdf.GetMargins()
+	_, bottom := b.pdf.GetAutoPageBreak()
+	return pageGeometry{Width: width, Height: height, Top: top, Bottom: bottom, CellMargin: b.pdf.GetCellMargin()}
 }
 
-func addExternalFont(pdf *fpdf.Fpdf, fontImport FontImport) {
-	pdf.SetFontLocation(fontImport.Directory)
-	for _, styleString := range []string{"", "B", "I", "BI"} {
-		if fontImport.FontFileNameBold != "" && (styleString == "B" || styleString == "BI") {
-			pdf.AddUTF8Font(fontImport.Name, styleString, fontImport.FontFileNameBold)
-		} else {
-			pdf.AddUTF8Font(fontImport.Name, styleString, fontImport.FontFileName)
+// draw adds the pages of layout to the pdf
+func (b *pdfBackend) draw(layout letterLayout) error {
+	pdf := b.pdf
+	// runs are placed exactly where their text starts
+	cellMargin := pdf.GetCellMargin()
+	pdf.SetCellMargin(0)
+	defer pdf.SetCellMargin(cellMargin)
+	for _, page := range layout.Pages {
+		pdf.AddPage()
+		for _, item := range page.Items {
+			switch item := item.(type) {
+			case layoutText:
+				for _, run := range item.Runs {
+					b.useFont(run.Family, item.Style, item.Size)
+					pdf.SetXY(run.X, item.Y)
+					pdf.CellFormat(run.Width, item.Height, b.fonts.translators[run.Family](run.Text), "", 0, "L", false, 0, "")
+				}
+			case layoutImage:
+				pdf.ImageOptions(item.File, item.X, item.Y, item.Width, item.Height, false, signatureImageOptions, 0, "")
+			case layoutRule:
+				pdf.Line(item.X1, item.Y1, item.X2, item.Y2)
+			}
 		}
 	}
+	return pdf.Error()
 }
 
-func render(inputFile string, defaultConfig Config) error {
+func render(inputFile string, defaultConfig Config, options Options) ([]Warning, error) {
 	pdf := fpdf.New("P", "mm", "A4", "")
 
-	utf8Fonts := []string{"dejavusanscondensed", "freeserif"}
-	for _, family := range utf8Fonts {
-		addEmbeddedFont(pdf, family)
-	}
-
-	var text []string
-	var configJson string
-	var subject = ""
-	var recipient []string
-	var bodyReached = false
-	var multiLineSubject = false
-	file, jsonReadError := os.Open(inputFile)
-	if jsonReadError != nil {
-		return jsonReadError
-	}
-	//goland:noinspection GoUnhandledErrorResult
-	defer file.Close()
-	scanner := bufio.NewScanner(file)
-	sectionIndex := 0
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if sectionSeparationRegex.MatchString(line) {
-			sectionIndex++
-			if LetterSection(sectionIndex) == Body {
-				bodyReached = true
-			}
-			continue
+	content, err := parseLetter(inputFile, defaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	config := content.config
+	if options.PDFA != "" {
+		config.PDFA = options.PDFA
+	}
+	pdfa, err := parsePdfaLevel(config.PDFA)
+	if err != nil {
+		return nil, err
+	}
+	embedSource := options.EmbedSource || config.EmbedSource
+	if pdfa != nil && (embedSource || len(config.Attachments) > 0) {
+		if pdfa.Part == 1 {
+			return nil, &configError{Field: "PDFA", Err: errors.New("PDF/A-1 does not allow embedded files, drop EmbedSource and Attachments")}
 		}
-		switch LetterSection(sectionIndex) {
-		case Initial:
-			// Tolerate freestyle text before the config section
-			continue
-		case Configuration:
-			configJson = configJson + line
-		case Subject:
-			if subject != "" {
-				/*
-				 Don't error out just yet in order to produce meaningful error messages. If we end up finding
-				 the correct count of config sections we will complain about the multiline subject.
-				 If however we detect missing config sections the multiline subject is just a symptom and we should
-				 really complain about missing sections.
-				*/
-				multiLineSubject = true
-			}
-			subject = line
-		case Address:
-			recipient = append(recipient, line)
-		case Body:
-			fallthrough // tolerate config separator in body
-		default:
-			text = append(text, scanner.Text())
+		return nil, &configError{Field: "PDFA", Err: errors.New("PDF/A-2 only allows embedding PDF/A files, drop EmbedSource and Attachments")}
+	}
+	if pdfa != nil {
+		if err = checkPdfaEnclosures(config.Enclosures, pdfa); err != nil {
+			return nil, err
 		}
 	}
-	for scanner.Scan() {
-		text = append(text, scanner.Text())
+	signer, err := loadSigner(options.SignKey, options.SignCert)
+	if err != nil {
+		return nil, err
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+	now, fixedNow, err := currentTime()
+	if err != nil {
+		return nil, err
 	}
-	if !bodyReached {
-		return errors.New("letters MUST have exactly four sections: config, address, subject, body (in this order), initiated by lines starting with //")
-	} else if multiLineSubject {
-		return errors.New("the subject section (third section) must only contain one single line")
+	reproducible := options.Reproducible || config.Reproducible || fixedNow
+	// without it, fpdf writes fonts and images in random order
+	pdf.SetCatalogSort(true)
+	protection, err := resolveProtection(config, options)
+	if err != nil {
+		return nil, err
 	}
-	utf8Config := defaultConfig
-	jsonParseError := json.Unmarshal([]byte(configJson), &utf8Config)
-	if jsonParseError != nil {
-		return jsonParseError
+	if protection != nil {
+		if pdfa != nil {
+			return nil, &configError{Field: "Protection", Err: errors.New("PDF/A does not allow encryption")}
+		} else if signer != nil {
+			return nil, &configError{Field: "Protection", Err: errors.New("password protected pdfs cannot be signed")}
+		} else if reproducible && protection.ownerPassword == "" {
+			return nil, &configError{Field: "Protection.OwnerPasswordFile", Err: errors.New("reproducible output needs an owner password, a random one would differ every time")}
+		}
+		for _, enclosure := range config.Enclosures {
+			// fpdf does not encrypt the pages it imports
+			if strings.EqualFold(filepath.Ext(enclosure), ".pdf") {
+				return nil, &configError{Field: "Protection", Err: errors.New("pdf Enclosures cannot be password protected")}
+			}
+		}
+		pdf.SetProtection(protection.permissions, protection.userPassword, protection.ownerPassword)
+	}
+	files, err := attachments(inputFile, embedSource, config)
+	if err != nil {
+		return nil, err
 	}
+	pdf.SetAttachments(files)
 
-	if utf8Config.FontImport != nil {
-		addExternalFont(pdf, *utf8Config.FontImport)
-		utf8Fonts = append(utf8Fonts, utf8Config.FontImport.Name)
+	fonts, err := newFontChain(pdf, config)
+	if err != nil {
+		return nil, err
 	}
+	backend := &pdfBackend{pdf: pdf, fonts: fonts, registered: map[string]bool{}}
 
-	fontName := utf8Config.FontName
-	var tr func(s string) string
-	if Contains(utf8Fonts, strings.ToLower(fontName)) {
-		tr = func(s string) string {
-			return s
-		}
-	} else {
-		tr = pdf.UnicodeTranslatorFromDescriptor("")
-	}
-
-	trSubject := tr(subject)
-	trRecipient := MapStrings(recipient, tr)
-	trText := MapStrings(text, tr)
-	trSenderName := tr(utf8Config.GetSenderNameOrEmpty())
-	trSignature := tr(utf8Config.GetSignatureOrEmpty())
-	config := Config{
-		utf8Config.FontName,
-		utf8Config.FontImport,
-		utf8Config.FontSize,
-		utf8Config.FontSizeSender,
-		utf8Config.FontSizeAddress,
-		utf8Config.LineHeight,
-		utf8Config.LineHeightAddress,
-		utf8Config.AddressSectionX,
-		utf8Config.AddressSectionY,
-		utf8Config.AddressSectionW,
-		utf8Config.DateY,
-		utf8Config.Margins,
-		tr(utf8Config.DatePrefix),
-		tr(utf8Config.Date),
-		MapStrings(utf8Config.Sender, tr),
-		&trSenderName,
-		&trSignature,
-	}
-
-	pdf.AddPage()
+	created := config.creationTime(now, fixedNow, reproducible)
+	pdf.SetCreationDate(created)
+	pdf.SetModificationDate(created)
+	setMetadata(pdf, config, content.subject)
 	pdf.SetMargins(config.Margins, 20, config.Margins)
-
-	// Sender
-	pdf.SetXY(config.AddressSectionX, config.AddressSectionY)
-	pdf.SetFont(config.FontName, "", config.FontSizeSender)
-	pdf.MultiCell(config.AddressSectionW, config.LineHeightAddress, strings.Join(config.Sender, ", "), "B", "L", false)
-
-	// Address
-	pdf.SetFont(config.FontName, "", config.FontSizeAddress)
-	for i := 0; i < len(trRecipient); i++ {
-		pdf.SetX(config.AddressSectionX)
-		pdf.MultiCell(config.AddressSectionW, config.LineHeightAddress, trRecipient[i], "", "L", false)
+	layout, err := layoutLetter(content, backend.geometry(), fonts, backend, now)
+	if err != nil {
+		return nil, err
+	}
+	if options.Strict && len(layout.Warnings) > 0 {
+		return nil, strictError(inputFile, layout.Warnings)
+	}
+	if err = backend.draw(layout); err != nil {
+		return nil, err
+	}
+	if err = appendEnclosures(pdf, config); err != nil {
+		return nil, err
+	}
+	field := signatureField{Page: 1}
+	if image := layout.Signature; image != nil {
+		// a cryptographic signature is shown where the image is
+		_, pageHeight := pdf.GetPageSize()
+		points := func(mm float64) float64 { return mm / pdf.PointToUnitConvert(1) }
+		field = signatureField{Page: layout.SignaturePage, Rect: [4]float64{points(image.X), points(pageHeight - image.Y - image.Height), points(image.X + image.Width), points(pageHeight - image.Y)}}
 	}
 
-	pdf.SetFont(config.FontName, "", config.FontSize)
-
-	// Date
-	pdf.SetXY(config.Margins, config.DateY)
-	pdf.MultiCell(0, config.LineHeight, config.DatePrefix+config.Date, "", "R", false)
+	output := pdfOutput{pdfa: pdfa, created: created, signer: signer, field: field, signed: time.Now()}
+	if reproducible {
+		// renumbering would break the encryption, which depends on the object numbers
+		output.normalize = protection == nil
+		output.signed = created
+	}
+	return layout.Warnings, writePdf(pdf, outputFileName(inputFile), output)
+}
 
-	// Subject
-	pdf.SetFont(config.FontName, "B", config.FontSize)
-	pdf.SetXY(config.Margins, config.DateY+config.LineHeight)
-	pdf.MultiCell(0, config.LineHeight, trSubject, "", "L", false)
-	pdf.SetFont(config.FontName, "", config.FontSize)
+// strictError reports the warnings that keep a letter from being rendered in strict mode
+func strictError(inputFile string, warnings []Warning) error {
+	problems := make([]string, len(warnings))
+	for i, warning := range warnings {
+		problems[i] = warning.String()
+	}
+	return fmt.Errorf("not rendering %s in strict mode:\n%s", inputFile, strings.Join(problems, "\n"))
+}
 
-	pdf.Ln(config.LineHeight)
+// pdfOutput describes how the pdf written by fpdf is post-processed
+type pdfOutput struct {
+	// normalize makes the file independent of the order in which fpdf writes imported pages, see normalizePdf
+	normalize bool
+	// pdfa is the PDF/A level to convert to, if any, created the creation date to use then
+	pdfa    *pdfaLevel
+	created time.Time
+	// signer signs the pdf, if set, at the time signed
+	signer *signer
+	field  signatureField
+	signed time.Time
+}
 
-	// Text
-	for i := 0; i < len(trText); i++ {
-		pdf.SetX(config.Margins)
-		pdf.MultiCell(0, config.LineHeight, trText[i], "", "L", false)
+// writePdf writes the pdf to fileName after post-processing it as output says: normalizing it, converting it to PDF/A
+// and signing it, in that order
+func writePdf(pdf *fpdf.Fpdf, fileName string, output pdfOutput) error {
+	var buffer bytes.Buffer
+	if err := pdf.Output(&buffer); err != nil {
+		return err
+	}
+	data := buffer.Bytes()
+	if output.normalize {
+		var err error
+		if data, err = normalizePdf(data); err != nil {
+			return err
+		}
+	}
+	if output.pdfa != nil {
+		var err error
+		if data, err = convertToPdfA(data, *output.pdfa, output.created); err != nil {
+			return fmt.Errorf("PDFA: %s", err)
+		}
 	}
+	if output.signer != nil {
+		var err error
+		if data, err = signPdf(data, *output.signer, output.field, output.signed); err != nil {
+			return fmt.Errorf("signing: %s", err)
+		}
+	}
+	return writeFileAtomic(fileName, data, 0644)
+}
 
-	if config.GetSignatureOrEmpty() != "" {
-		var opt fpdf.ImageOptions
-		opt.ImageType = "jpg"
-		pdf.ImageOptions(config.GetSignatureOrEmpty(), pdf.GetX(), pdf.GetY(), 0, 0, true, opt, 0, "")
+// setMetadata fills in the document information, see documentTitle and documentAuthor
+func setMetadata(pdf *fpdf.Fpdf, config Config, subject string) {
+	if title := config.documentTitle(subject); title != "" {
+		pdf.SetTitle(title, true)
+	}
+	if author := config.documentAuthor(); author != "" {
+		pdf.SetAuthor(author, true)
 	}
-	pdf.Ln(config.LineHeight)
-	pdf.MultiCell(0, config.LineHeight, config.GetSenderNameOrEmpty(), "", "L", false)
+	if config.Metadata.Subject != "" {
+		pdf.SetSubject(config.Metadata.Subject, true)
+	}
+	if config.Metadata.Keywords != "" {
+		pdf.SetKeywords(config.Metadata.Keywords, true)
+	}
+	pdf.SetCreator("left "+strings.TrimSpace(Version()), true)
+}
 
-	pdfErr := pdf.OutputFileAndClose(strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".pdf")
-	return pdfErr
+// outputFileName returns the name of the pdf rendered from inputFile
+func outputFileName(inputFile string) string {
+	return formatFileName(inputFile, "pdf")
 }
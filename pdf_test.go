@@ -0,0 +1,212 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-pdf/fpdf"
+)
+
+func TestRenderErrors(t *testing.T) {
+	dir := t.TempDir()
+	cwd, _ := os.Getwd()
+	fonts := filepath.Join(cwd, "test", "fonts")
+	signature := filepath.Join(cwd, "test", "it", "pdf", "Signature.jpg")
+	if err := os.WriteFile(filepath.Join(dir, "broken.ttf"), []byte("this is not a font"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.pdf"), []byte("this is not a pdf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "directory.pdf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		config string
+		file   string
+		want   string
+	}{
+		{
+			name:   "missing regular font file",
+			config: `{"FontName": "noto", "FontImport": {"Name": "noto", "Directory": "` + fonts + `", "FontFileName": "missing.ttf"}}`,
+			want:   "FontImport.FontFileName: " + filepath.Join(fonts, "missing.ttf") + " not found",
+		},
+		{
+			name: "missing bold font file",
+			config: `{"FontName": "noto", "FontImport": {"Name": "noto", "Directory": "` + fonts +
+				`", "FontFileName": "NotoSans-Condensed.ttf", "FontFileNameBold": "missing.ttf"}}`,
+			want: "FontImport.FontFileNameBold: " + filepath.Join(fonts, "missing.ttf") + " not found",
+		},
+		{
+			name:   "font file in an unsupported format",
+			config: `{"FontName": "noto", "FontImport": {"Name": "noto", "Directory": "` + dir + `", "FontFileName": "broken.ttf"}}`,
+			want:   "FontImport.FontFileName: " + filepath.Join(dir, "broken.ttf") + `: unsupported font format (signature "this")`,
+		},
+		{
+			name:   "unknown font",
+			config: `{"FontName": "no such font"}`,
+			want:   `FontName: unknown font "no such font", it is neither embedded, imported, a core font nor installed`,
+		},
+		{
+			name:   "missing signature",
+			config: `{"Signature": "` + filepath.Join(dir, "missing.jpg") + `"}`,
+			want:   "Signature: " + filepath.Join(dir, "missing.jpg") + " not found",
+		},
+		{
+			name:   "signature that is no jpeg",
+			config: `{"Signature": "` + filepath.Join(dir, "broken.ttf") + `"}`,
+			want:   "Signature: ",
+		},
+		{
+			name:   "missing attachment",
+			config: `{"Attachments": ["` + filepath.Join(dir, "missing.csv") + `"]}`,
+			want:   "Attachments: " + filepath.Join(dir, "missing.csv") + " not found",
+		},
+		{
+			name:   "missing enclosure",
+			config: `{"Enclosures": ["` + filepath.Join(dir, "missing.pdf") + `"]}`,
+			want:   "Enclosures: " + filepath.Join(dir, "missing.pdf") + " not found",
+		},
+		{
+			name:   "enclosure of an unsupported type",
+			config: `{"Enclosures": ["` + filepath.Join(dir, "broken.ttf") + `"]}`,
+			want:   "Enclosures: " + filepath.Join(dir, "broken.ttf") + " is neither a pdf nor a jpeg, png or gif image",
+		},
+		{
+			name:   "enclosure that is no pdf",
+			config: `{"Enclosures": ["` + filepath.Join(dir, "broken.pdf") + `"]}`,
+			want:   "Enclosures: " + filepath.Join(dir, "broken.pdf") + " is not a pdf file",
+		},
+		{
+			name:   "invalid direction",
+			config: `{"Direction": "up"}`,
+			want:   `Direction: "up" is neither "ltr" nor "rtl"`,
+		},
+		// the pdf is written to a temporary file first, which cannot replace a directory
+		{
+			name:   "unwritable output file",
+			config: `{"Signature": "` + signature + `"}`,
+			file:   "directory.left",
+			want:   "rename " + filepath.Join(dir, ".directory.pdf."),
+		},
+	}
+	t.Setenv("XDG_DATA_HOME", "/nonexistent")
+	t.Setenv("XDG_DATA_DIRS", "/nonexistent")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := test.file
+			if file == "" {
+				file = "input.left"
+			}
+			inputFile := filepath.Join(dir, file)
+			if err := os.WriteFile(inputFile, []byte(letterWithConfig(test.config)), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := render(inputFile, defaultConfig, Options{})
+			if err == nil {
+				t.Fatalf("expected an error starting with %q", test.want)
+			}
+			if !strings.HasPrefix(err.Error(), test.want) {
+				t.Errorf("got error %q, wanted it to start with %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedFontErrors(t *testing.T) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	addEmbeddedFont(pdf, "nosuchfont", "B")
+	AssertEquals(t, pdf.Error().Error(), "embedded font nosuchfont: open fonts/nosuchfont/bold.ttf: file does not exist", "error")
+	AssertEquals(t, partError(pdf.Error(), "subject").Error(), "subject: embedded font nosuchfont: open fonts/nosuchfont/bold.ttf: file does not exist", "part error")
+}
+
+func TestDirectionError(t *testing.T) {
+	_, err := parseLetter(writeTestLetter(t, letterWithConfig(`{"Direction": "up"}`)), defaultConfig)
+	var fieldError *configError
+	if !errors.As(err, &fieldError) {
+		t.Fatalf("expected a config error, got %v", err)
+	}
+	AssertEquals(t, fieldError.Field, "Direction", "field")
+}
+
+func TestPartErrorKeepsConfigFields(t *testing.T) {
+	AssertEquals(t, partError(nil, "body"), nil, "no error")
+	err := partError(&configError{Field: "FontImport.FontFileNameItalic", Err: os.ErrNotExist}, "body")
+	AssertEquals(t, err.Error(), "FontImport.FontFileNameItalic: file does not exist", "error")
+}
+
+func TestMetadata(t *testing.T) {
+	info := func(data []byte) pdfObject {
+		objects, err := readPdfObjects(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, infoNumber, err := readPdfTrailer(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return objects[infoNumber]
+	}
+	entry := func(info pdfObject, key string) string {
+		value, _ := info.stringEntry(key)
+		return value
+	}
+
+	derived := info(renderPdf(t, "// config\n{\"SenderName\": \"Łukasz Nowak\", \"Date\": \"2023-06-01\"}\n"+
+		"// address\nName\n// subject\nYour invoice 4711 \n// body\nBody\n", Options{}))
+	AssertEquals(t, entry(derived, "Title"), "Your invoice 4711", "title from the subject line")
+	AssertEquals(t, entry(derived, "Author"), "Łukasz Nowak", "author from SenderName")
+	AssertEquals(t, entry(derived, "Creator"), "left "+strings.TrimSpace(Version()), "creator")
+	AssertEquals(t, entry(derived, "CreationDate"), "D:20230601000000", "creation date from Date")
+	_, hasSubject := derived.stringEntry("Subject")
+	AssertEquals(t, hasSubject, false, "no subject")
+
+	overridden := info(renderPdf(t, "// config\n{\"SenderName\": \"Łukasz Nowak\", \"Metadata\": {\"Title\": \"Invoice\", "+
+		"\"Author\": \"ACME Corp.\", \"Subject\": \"Billing\", \"Keywords\": \"invoice, 2023\"}}\n"+
+		"// address\nName\n// subject\nYour invoice 4711\n// body\nBody\n", Options{}))
+	AssertEquals(t, entry(overridden, "Title"), "Invoice", "title")
+	AssertEquals(t, entry(overridden, "Author"), "ACME Corp.", "author")
+	AssertEquals(t, entry(overridden, "Subject"), "Billing", "subject")
+	AssertEquals(t, entry(overridden, "Keywords"), "invoice, 2023", "keywords")
+}
+
+func TestReproducibleOutput(t *testing.T) {
+	letter := "// config\n{\"Signature\": \"./test/it/pdf/Signature.jpg\", \"FontFallback\": [\"freeserif\"]}\n" +
+		"// address\nName\n// subject\nSubject ∑\n// body\nBody\n"
+	first := renderPdf(t, letter, Options{Reproducible: true})
+	second := renderPdf(t, letter, Options{Reproducible: true})
+	AssertEquals(t, bytes.Equal(first, second), true, "byte-identical output")
+	if !bytes.Contains(first, []byte("/ID [<")) {
+		t.Error("the document id is missing")
+	}
+
+	t.Setenv(sourceDateEpochVariable, "1700000000")
+	data := renderPdf(t, letter, Options{})
+	if !bytes.Contains(data, []byte("/CreationDate (D:20231114221320)")) {
+		t.Error("SOURCE_DATE_EPOCH is not the creation date")
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type pdfaLevel struct {
+	Part        int
+	Conformance string
+	// Version is the pdf version the part is based on
+	Version string
+}
+
+var pdfaLevels = map[string]pdfaLevel{
+	"1b": {Part: 1, Conformance: "B", Version: "1.4"},
+	"2b": {Part: 2, Conformance: "B", Version: "1.7"},
+}
+
+// parsePdfaLevel returns the PDF/A level selected by the PDFA config field, which is nil if PDF/A is not wanted
+func parsePdfaLevel(value string) (*pdfaLevel, error) {
+	if value == "" {
+		return nil, nil
+	}
+	level, ok := pdfaLevels[strings.ToLower(value)]
+	if !ok {
+		return nil, &configError{Field: "PDFA", Err: fmt.Errorf("%q is neither \"1b\" nor \"2b\"", value)}
+	}
+	return &level, nil
+}
+
+var (
+	pdfDateEntry      = regexp.MustCompile(`/(CreationDate|ModDate)\s*\([^)]*\)`)
+	pdfEmbeddedFiles  = regexp.MustCompile(`/Names\s*<<\s*/EmbeddedFiles\s*<<\s*/Names\s*\[\s*\]\s*>>\s*>>`)
+	pdfFontDescriptor = regexp.MustCompile(`/FontDescriptor (\d+) \d+ R`)
+	pdfCidToGidMap    = regexp.MustCompile(`/CIDToGIDMap (\d+) \d+ R`)
+)
+
+// convertToPdfA turns a pdf written by fpdf into a PDF/A file of the given level: it adds XMP metadata matching the
+// document information, an sRGB output intent and a document id, and rewrites the file with a binary header comment.
+// The dates of the document information are replaced by created including its time zone.
+func convertToPdfA(data []byte, level pdfaLevel, created time.Time) ([]byte, error) {
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		return nil, err
+	}
+	root, infoNumber, err := readPdfTrailer(data)
+	if err != nil {
+		return nil, err
+	}
+	catalog, ok := objects[root]
+	if !ok {
+		return nil, errors.New("the catalog is missing")
+	}
+	next := 0
+	for number := range objects {
+		if number >= next {
+			next = number + 1
+		}
+	}
+	add := func(dict string, stream []byte) int {
+		objects[next] = pdfObject{Number: next, Dict: dict, Stream: stream}
+		next++
+		return next - 1
+	}
+
+	info := objects[infoNumber]
+	if infoNumber == 0 {
+		infoNumber = add("<<\n>>", nil)
+		info = objects[infoNumber]
+	}
+	info.Dict = pdfDateEntry.ReplaceAllString(info.Dict, "")
+	info.Dict = withEntries(info.Dict, "/CreationDate ("+pdfDate(created)+")", "/ModDate ("+pdfDate(created)+")")
+	objects[infoNumber] = info
+
+	metadata := xmpMetadata(info, level, created)
+	metadataNumber := add(fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>", len(metadata)), metadata)
+	profile := srgbProfile()
+	profileNumber := add(fmt.Sprintf("<< /N 3 /Length %d >>", len(profile)), profile)
+
+	if level.Part == 1 {
+		// PDF/A-1 forbids embedded files altogether, even an empty list of them
+		if strings.Contains(pdfEmbeddedFiles.ReplaceAllString(catalog.Dict, ""), "/EmbeddedFiles") {
+			return nil, errors.New("PDF/A-1 does not allow embedded files")
+		}
+		catalog.Dict = pdfEmbeddedFiles.ReplaceAllString(catalog.Dict, "")
+		// PDF/A-1 requires subset CID fonts to list the glyphs they contain
+		for _, number := range sortedNumbers(objects) {
+			object := objects[number]
+			if object.name("Subtype") != "CIDFontType2" {
+				continue
+			}
+			descriptorRef := pdfFontDescriptor.FindStringSubmatch(object.Dict)
+			mapRef := pdfCidToGidMap.FindStringSubmatch(object.Dict)
+			if descriptorRef == nil || mapRef == nil {
+				continue
+			}
+			descriptorNumber, _ := strconv.Atoi(descriptorRef[1])
+			mapNumber, _ := strconv.Atoi(mapRef[1])
+			cidToGid, err := objects[mapNumber].decodedStream()
+			if err != nil {
+				return nil, fmt.Errorf("CIDToGIDMap of %s: %s", object.name("BaseFont"), err)
+			}
+			cidSet := deflate(cidSetOf(cidToGid))
+			cidSetNumber := add(fmt.Sprintf("<< /Filter /FlateDecode /Length %d >>", len(cidSet)), cidSet)
+			descriptor := objects[descriptorNumber]
+			descriptor.Dict = withEntries(descriptor.Dict, fmt.Sprintf("/CIDSet %d 0 R", cidSetNumber))
+			objects[descriptorNumber] = descriptor
+		}
+	}
+	catalog.Dict = withEntries(catalog.Dict,
+		fmt.Sprintf("/Metadata %d 0 R", metadataNumber),
+		fmt.Sprintf("/OutputIntents [<< /Type /OutputIntent /S /GTS_PDFA1 /OutputConditionIdentifier (sRGB IEC61966-2.1) "+
+			"/Info (sRGB IEC61966-2.1) /DestOutputProfile %d 0 R >>]", profileNumber))
+	objects[root] = catalog
+
+	id := md5.Sum(data)
+	return writePdfObjects(level.Version, objects, root, infoNumber, id[:]), nil
+}
+
+// cidSetOf returns a bit set of the CIDs that a CIDToGIDMap maps to glyphs, CID 0 is always present
+func cidSetOf(cidToGid []byte) []byte {
+	cids := len(cidToGid) / 2
+	set := make([]byte, (cids+7)/8)
+	for cid := 0; cid < cids; cid++ {
+		if cid == 0 || cidToGid[2*cid] != 0 || cidToGid[2*cid+1] != 0 {
+			set[cid/8] |= 0x80 >> (cid % 8)
+		}
+	}
+	return set
+}
+
+func deflate(data []byte) []byte {
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	_, _ = writer.Write(data)
+	_ = writer.Close()
+	return buffer.Bytes()
+}
+
+// pdfDate formats t as a pdf date including its time zone, e.g. "D:20230601120000+02'00'"
+func pdfDate(t time.Time) string {
+	zone := t.Format("-07:00")
+	return "D:" + t.Format("20060102150405") + strings.Replace(zone, ":", "'", 1) + "'"
+}
+
+// xmpMetadata describes the document in XMP, repeating the document information as PDF/A requires
+func xmpMetadata(info pdfObject, level pdfaLevel, created time.Time) []byte {
+	escape := func(s string) string {
+		var buffer bytes.Buffer
+		_ = xml.EscapeText(&buffer, []byte(s))
+		return buffer.String()
+	}
+	date := created.Format("2006-01-02T15:04:05-07:00")
+	var sb strings.Builder
+	sb.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	sb.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	sb.WriteString("<rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	sb.WriteString("<rdf:Description rdf:about=\"\" xmlns:pdfaid=\"http://www.aiim.org/pdfa/ns/id/\">\n")
+	fmt.Fprintf(&sb, "<pdfaid:part>%d</pdfaid:part>\n<pdfaid:conformance>%s</pdfaid:conformance>\n", level.Part, level.Conformance)
+	sb.WriteString("</rdf:Description>\n")
+	sb.WriteString("<rdf:Description rdf:about=\"\" xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\">\n")
+	fmt.Fprintf(&sb, "<xmp:CreateDate>%s</xmp:CreateDate>\n<xmp:ModifyDate>%s</xmp:ModifyDate>\n", date, date)
+	fmt.Fprintf(&sb, "<xmp:MetadataDate>%s</xmp:MetadataDate>\n", date)
+	if creator, ok := info.stringEntry("Creator"); ok {
+		fmt.Fprintf(&sb, "<xmp:CreatorTool>%s</xmp:CreatorTool>\n", escape(creator))
+	}
+	sb.WriteString("</rdf:Description>\n")
+	sb.WriteString("<rdf:Description rdf:about=\"\" xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\">\n")
+	if producer, ok := info.stringEntry("Producer"); ok {
+		fmt.Fprintf(&sb, "<pdf:Producer>%s</pdf:Producer>\n", escape(producer))
+	}
+	if keywords, ok := info.stringEntry("Keywords"); ok {
+		fmt.Fprintf(&sb, "<pdf:Keywords>%s</pdf:Keywords>\n", escape(keywords))
+	}
+	sb.WriteString("</rdf:Description>\n")
+	sb.WriteString("<rdf:Description rdf:about=\"\" xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n")
+	if title, ok := info.stringEntry("Title"); ok {
+		fmt.Fprintf(&sb, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", escape(title))
+	}
+	if author, ok := info.stringEntry("Author"); ok {
+		fmt.Fprintf(&sb, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", escape(author))
+	}
+	if subject, ok := info.stringEntry("Subject"); ok {
+		fmt.Fprintf(&sb, "<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", escape(subject))
+	}
+	sb.WriteString("</rdf:Description>\n")
+	sb.WriteString("</rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>")
+	return []byte(sb.String())
+}
@@ -0,0 +1,158 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func renderPdf(t *testing.T, letter string, options Options) []byte {
+	inputFile, err := renderTestLetter(t, letter, "pdf", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readTestOutput(t, inputFile, "pdf")
+}
+
+// referencedObject returns the object referenced by the entry key of the dictionary of o
+func referencedObject(t *testing.T, objects map[int]pdfObject, o pdfObject, key string) pdfObject {
+	match := regexp.MustCompile(`/` + key + ` (\d+) 0 R`).FindStringSubmatch(o.Dict)
+	if match == nil {
+		t.Fatalf("no %s in %s", key, o.Dict)
+	}
+	number, _ := strconv.Atoi(match[1])
+	object, ok := objects[number]
+	if !ok {
+		t.Fatalf("%s %d does not exist", key, number)
+	}
+	return object
+}
+
+func TestPdfAConformanceMarkers(t *testing.T) {
+	letter := letterWithConfig(`{"Signature": "./test/it/pdf/Signature.jpg"}`)
+	for level, want := range map[string]struct{ version, part string }{"1b": {"1.4", "1"}, "2B": {"1.7", "2"}} {
+		data := renderPdf(t, letter, Options{PDFA: level})
+		if !bytes.HasPrefix(data, []byte("%PDF-"+want.version+"\n%\xe2\xe3\xcf\xd3\n")) {
+			t.Errorf("%s: unexpected header %q", level, data[:20])
+		}
+		objects, err := readPdfObjects(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every cross reference entry points at its object
+		xref := regexp.MustCompile(`(?m)^(\d{10}) 00000 n $`).FindAllSubmatch(data, -1)
+		AssertEquals(t, len(xref), len(objects), level+" xref entries")
+		for i, entry := range xref {
+			offset, _ := strconv.Atoi(string(entry[1]))
+			if !bytes.HasPrefix(data[offset:], []byte(strconv.Itoa(i+1)+" 0 obj")) {
+				t.Errorf("%s: xref entry of object %d is off", level, i+1)
+			}
+		}
+		if !regexp.MustCompile(`/ID \[<[0-9A-F]{32}> <[0-9A-F]{32}>\]`).Match(data) {
+			t.Errorf("%s: the trailer has no document id", level)
+		}
+
+		root, infoNumber, err := readPdfTrailer(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		catalog := objects[root]
+		metadata := referencedObject(t, objects, catalog, "Metadata")
+		AssertEquals(t, metadata.name("Filter"), "", level+" metadata filter")
+		xmp := string(metadata.Stream)
+		for _, marker := range []string{"<pdfaid:part>" + want.part + "</pdfaid:part>", "<pdfaid:conformance>B</pdfaid:conformance>"} {
+			if !strings.Contains(xmp, marker) {
+				t.Errorf("%s: %s missing in metadata", level, marker)
+			}
+		}
+		createDate := regexp.MustCompile(`<xmp:CreateDate>(.*)</xmp:CreateDate>`).FindStringSubmatch(xmp)
+		xmpDate, err := time.Parse(time.RFC3339, createDate[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		creationDate, _ := objects[infoNumber].stringEntry("CreationDate")
+		infoDate, err := time.Parse("D:20060102150405-07'00'", creationDate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertEquals(t, infoDate.Equal(xmpDate), true, level+" dates match")
+		producer, _ := objects[infoNumber].stringEntry("Producer")
+		if !strings.Contains(xmp, "<pdf:Producer>"+producer+"</pdf:Producer>") {
+			t.Errorf("%s: producer %q missing in metadata", level, producer)
+		}
+
+		if !strings.Contains(catalog.Dict, "/S /GTS_PDFA1") {
+			t.Errorf("%s: no PDF/A output intent in %s", level, catalog.Dict)
+		}
+		profile := referencedObject(t, objects, catalog, "DestOutputProfile")
+		AssertEquals(t, strings.Contains(profile.Dict, "/N 3"), true, level+" profile components")
+		AssertEquals(t, string(profile.Stream[36:40]), "acsp", level+" profile signature")
+		AssertEquals(t, string(profile.Stream[16:20]), "RGB ", level+" profile color space")
+		AssertEquals(t, int(binary.BigEndian.Uint32(profile.Stream)), len(profile.Stream), level+" profile size")
+
+		stats, err := readPdfStats(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, font := range stats.Fonts {
+			AssertEquals(t, font.Embedded, true, level+" "+font.Name+" embedded")
+		}
+		for _, object := range objects {
+			if object.name("Subtype") == "CIDFontType2" {
+				descriptor := referencedObject(t, objects, object, "FontDescriptor")
+				AssertEquals(t, strings.Contains(descriptor.Dict, "/CIDSet"), want.part == "1", level+" CIDSet")
+			}
+		}
+		AssertEquals(t, strings.Contains(catalog.Dict, "/EmbeddedFiles"), want.part != "1", level+" embedded files")
+	}
+}
+
+func TestPdfARejectsCoreFontsAndUnknownLevels(t *testing.T) {
+	inputFile := writeTestLetter(t, letterWithConfig(`{"PDFA": "2b", "FontFallback": ["helvetica"]}`))
+	_, err := render(inputFile, defaultConfig, Options{})
+	AssertEquals(t, err.Error(), "FontFallback: helvetica is a core font, which is not embedded in the pdf as PDF/A requires", "core font")
+	_, err = render(inputFile, defaultConfig, Options{PDFA: "3u"})
+	AssertEquals(t, err.Error(), `PDFA: "3u" is neither "1b" nor "2b"`, "unknown level")
+	_, err = render(inputFile, defaultConfig, Options{PDFA: "1b", EmbedSource: true})
+	AssertEquals(t, err.Error(), "PDFA: PDF/A-1 does not allow embedded files, drop EmbedSource and Attachments", "embedded source")
+}
+
+func TestSrgbProfile(t *testing.T) {
+	profile := srgbProfile()
+	AssertEquals(t, int(binary.BigEndian.Uint32(profile)), len(profile), "size")
+	AssertEquals(t, binary.BigEndian.Uint32(profile[8:]), uint32(0x02100000), "version")
+	tags := int(binary.BigEndian.Uint32(profile[128:]))
+	AssertEquals(t, tags, 9, "tags")
+	for i := 0; i < tags; i++ {
+		entry := profile[132+12*i:]
+		offset := binary.BigEndian.Uint32(entry[4:])
+		size := binary.BigEndian.Uint32(entry[8:])
+		if offset%4 != 0 || int(offset+size) > len(profile) {
+			t.Errorf("tag %s at %d with %d bytes is misplaced", entry[0:4], offset, size)
+		}
+	}
+}
@@ -0,0 +1,633 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+var update = flag.Bool("update", false, "regenerate the expected layouts of the pdf integration tests")
+
+/*
+pdfLayout describes what the pages of a pdf show, one item per line: the page sizes, the text runs with their
+position, font and size, the images with their placement and the painted lines and rectangles. Coordinates are in
+points from the lower left corner of the page. Unlike a byte comparison it ignores how fpdf arranges the objects,
+compresses the streams or subsets the fonts.
+*/
+func pdfLayout(data []byte) (string, error) {
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		return "", err
+	}
+	root, _, err := readPdfTrailer(data)
+	if err != nil {
+		return "", err
+	}
+	reader := &layoutReader{objects: objects, fonts: map[string]*layoutFont{}}
+	catalog, err := reader.dict(strconv.Itoa(root) + " 0 R")
+	if err != nil {
+		return "", err
+	}
+	if err = reader.readPages(catalog["/Pages"], pdfDict{}); err != nil {
+		return "", err
+	}
+	return strings.Join(reader.lines, "\n") + "\n", nil
+}
+
+// pdfMatrix is a transformation matrix [a b c d e f] as used by the cm and Tm operators
+type pdfMatrix [6]float64
+
+var identityMatrix = pdfMatrix{1, 0, 0, 1, 0, 0}
+
+// times returns the transformation that applies m first and n second
+func (m pdfMatrix) times(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+func (m pdfMatrix) apply(x float64, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// layoutFont maps the character codes of a font to text, using its ToUnicode cmap if it has one and cp1252
+// otherwise, which is what fpdf uses for its core fonts
+type layoutFont struct {
+	name       string
+	codeLength int
+	ranges     []cmapRange
+}
+
+// cmapRange maps the codes from low to high to text. Either each code has its own value, or the codes are mapped
+// to consecutive characters starting with base.
+type cmapRange struct {
+	low, high int
+	base      []uint16
+	values    []string
+}
+
+func (f *layoutFont) decode(text []byte) string {
+	if f.ranges == nil {
+		decoded, _ := charmap.Windows1252.NewDecoder().Bytes(text)
+		return string(decoded)
+	}
+	var sb strings.Builder
+	for i := 0; i+f.codeLength <= len(text); i += f.codeLength {
+		code := 0
+		for _, b := range text[i : i+f.codeLength] {
+			code = code<<8 | int(b)
+		}
+		sb.WriteString(f.lookup(code))
+	}
+	return sb.String()
+}
+
+func (f *layoutFont) lookup(code int) string {
+	for _, r := range f.ranges {
+		if code < r.low || code > r.high {
+			continue
+		}
+		if r.values != nil {
+			return r.values[code-r.low]
+		}
+		units := append([]uint16{}, r.base...)
+		units[len(units)-1] += uint16(code - r.low)
+		return string(utf16.Decode(units))
+	}
+	return "\uFFFD"
+}
+
+// parseCmap reads the code length and the bfchar and bfrange mappings of a ToUnicode cmap
+func parseCmap(data []byte) (*layoutFont, error) {
+	font := &layoutFont{codeLength: 1}
+	lexer := &pdfLexer{data: data}
+	var operands []any
+	for {
+		start := lexer.pos
+		if _, more, err := lexer.next(); err != nil {
+			return nil, err
+		} else if !more {
+			return font, nil
+		}
+		lexer.pos = start
+		value, err := lexer.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		token, _ := value.(string)
+		switch token {
+		case "endcodespacerange":
+			if len(operands) > 0 {
+				font.codeLength = len(hexBytes(operands[0].(string)))
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				code := hexCode(operands[i].(string))
+				font.ranges = append(font.ranges, cmapRange{low: code, high: code, base: hexUnits(operands[i+1].(string))})
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				r := cmapRange{low: hexCode(operands[i].(string)), high: hexCode(operands[i+1].(string))}
+				if values, ok := operands[i+2].([]any); ok {
+					for _, value := range values {
+						r.values = append(r.values, string(utf16.Decode(hexUnits(value.(string)))))
+					}
+					for len(r.values) <= r.high-r.low {
+						r.values = append(r.values, "\uFFFD")
+					}
+				} else {
+					r.base = hexUnits(operands[i+2].(string))
+				}
+				font.ranges = append(font.ranges, r)
+			}
+		}
+		if strings.HasPrefix(token, "begin") || strings.HasPrefix(token, "end") {
+			operands = nil
+		} else {
+			operands = append(operands, value)
+		}
+	}
+}
+
+func hexBytes(token string) []byte {
+	digits := strings.Join(strings.Fields(strings.Trim(token, "<>")), "")
+	if len(digits)%2 == 1 {
+		digits += "0"
+	}
+	decoded, _ := hex.DecodeString(digits)
+	return decoded
+}
+
+func hexCode(token string) int {
+	code := 0
+	for _, b := range hexBytes(token) {
+		code = code<<8 | int(b)
+	}
+	return code
+}
+
+func hexUnits(token string) []uint16 {
+	decoded := hexBytes(token)
+	units := make([]uint16, 0, len(decoded)/2)
+	for i := 0; i+1 < len(decoded); i += 2 {
+		units = append(units, uint16(decoded[i])<<8|uint16(decoded[i+1]))
+	}
+	if len(units) == 0 {
+		units = append(units, 0xFFFD)
+	}
+	return units
+}
+
+// pdfStringBytes returns the bytes of a literal or hex string token
+func pdfStringBytes(token string) []byte {
+	if strings.HasPrefix(token, "<") {
+		return hexBytes(token)
+	}
+	return unescapePdfString(token)
+}
+
+type layoutReader struct {
+	objects map[int]pdfObject
+	fonts   map[string]*layoutFont
+	lines   []string
+	pages   int
+}
+
+// resolve follows value if it is a reference
+func (r *layoutReader) resolve(value any) (any, error) {
+	token, ok := value.(string)
+	if !ok || !strings.HasSuffix(token, " R") {
+		return value, nil
+	}
+	number, _ := strconv.Atoi(strings.Fields(token)[0])
+	object, found := r.objects[number]
+	if !found {
+		return nil, fmt.Errorf("object %d does not exist", number)
+	}
+	return (&pdfLexer{data: []byte(object.Dict)}).parseValue()
+}
+
+func (r *layoutReader) dict(value any) (pdfDict, error) {
+	resolved, err := r.resolve(value)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil {
+		return pdfDict{}, nil
+	}
+	dict, ok := resolved.(pdfDict)
+	if !ok {
+		return nil, fmt.Errorf("%v is no dictionary", value)
+	}
+	return dict, nil
+}
+
+func (r *layoutReader) numbers(value any) ([]float64, error) {
+	resolved, err := r.resolve(value)
+	if err != nil {
+		return nil, err
+	}
+	array, _ := resolved.([]any)
+	numbers := make([]float64, len(array))
+	for i, item := range array {
+		if numbers[i], err = strconv.ParseFloat(fmt.Sprint(item), 64); err != nil {
+			return nil, err
+		}
+	}
+	return numbers, nil
+}
+
+func (r *layoutReader) stream(value any) ([]byte, error) {
+	token, ok := value.(string)
+	if !ok || !strings.HasSuffix(token, " R") {
+		return nil, fmt.Errorf("%v is no stream reference", value)
+	}
+	number, _ := strconv.Atoi(strings.Fields(token)[0])
+	return r.objects[number].decodedStream()
+}
+
+// readPages walks the page tree, the media box and the resources are inherited from the parent nodes
+func (r *layoutReader) readPages(value any, inherited pdfDict) error {
+	node, err := r.dict(value)
+	if err != nil {
+		return err
+	}
+	attributes := pdfDict{}
+	for _, key := range []string{"/MediaBox", "/Resources"} {
+		attributes[key] = inherited[key]
+		if own, found := node[key]; found {
+			attributes[key] = own
+		}
+	}
+	if node["/Type"] == "/Pages" {
+		kids, _ := node["/Kids"].([]any)
+		for _, kid := range kids {
+			if err = r.readPages(kid, attributes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	r.pages++
+	box, err := r.numbers(attributes["/MediaBox"])
+	if err != nil || len(box) != 4 {
+		return fmt.Errorf("page %d has no valid MediaBox", r.pages)
+	}
+	r.add("page %d %s %s", r.pages, number(box[2]-box[0]), number(box[3]-box[1]))
+	contents, err := r.resolve(node["/Contents"])
+	if err != nil {
+		return err
+	}
+	streams, isArray := contents.([]any)
+	if !isArray {
+		streams = []any{node["/Contents"]}
+	}
+	var content []byte
+	for _, stream := range streams {
+		data, err := r.stream(stream)
+		if err != nil {
+			return err
+		}
+		content = append(append(content, data...), '\n')
+	}
+	resources, err := r.dict(attributes["/Resources"])
+	if err != nil {
+		return err
+	}
+	return r.readContent(content, resources, identityMatrix)
+}
+
+func (r *layoutReader) add(format string, args ...any) {
+	r.lines = append(r.lines, fmt.Sprintf(format, args...))
+}
+
+func number(value float64) string {
+	formatted := strconv.FormatFloat(value, 'f', 2, 64)
+	if formatted == "-0.00" {
+		return "0.00"
+	}
+	return formatted
+}
+
+func (r *layoutReader) font(resources pdfDict, name string) (*layoutFont, error) {
+	fonts, err := r.dict(resources["/Font"])
+	if err != nil {
+		return nil, err
+	}
+	reference, _ := fonts[name].(string)
+	if cached, found := r.fonts[reference]; found {
+		return cached, nil
+	}
+	dict, err := r.dict(reference)
+	if err != nil {
+		return nil, fmt.Errorf("font %s: %w", name, err)
+	}
+	font := &layoutFont{}
+	if toUnicode, found := dict["/ToUnicode"]; found {
+		cmap, err := r.stream(toUnicode)
+		if err != nil {
+			return nil, err
+		}
+		if font, err = parseCmap(cmap); err != nil {
+			return nil, fmt.Errorf("font %s: %w", name, err)
+		}
+	}
+	font.name, _ = dict["/BaseFont"].(string)
+	font.name = strings.TrimPrefix(font.name, "/")
+	if subset := strings.IndexByte(font.name, '+'); subset == 6 {
+		font.name = font.name[subset+1:]
+	}
+	r.fonts[reference] = font
+	return font, nil
+}
+
+type layoutState struct {
+	ctm  pdfMatrix
+	font *layoutFont
+	size float64
+}
+
+func isPdfOperator(token string) bool {
+	if token == "" || token == "true" || token == "false" || token == "null" {
+		return false
+	}
+	c := token[0]
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '\'' || c == '"'
+}
+
+// readContent interprets the operators of a content stream that place text, images and paths
+func (r *layoutReader) readContent(content []byte, resources pdfDict, ctm pdfMatrix) error {
+	lexer := &pdfLexer{data: content}
+	state := layoutState{ctm: ctm}
+	var saved []layoutState
+	var operands []any
+	textMatrix, lineMatrix := identityMatrix, identityMatrix
+	leading := 0.0
+	var path []string
+	var currentX, currentY float64
+	operand := func(i int) float64 {
+		if len(operands) < i+1 {
+			return 0
+		}
+		value, _ := strconv.ParseFloat(fmt.Sprint(operands[i]), 64)
+		return value
+	}
+	matrix := func() pdfMatrix {
+		return pdfMatrix{operand(0), operand(1), operand(2), operand(3), operand(4), operand(5)}
+	}
+	moveText := func(tx float64, ty float64) {
+		lineMatrix = pdfMatrix{1, 0, 0, 1, tx, ty}.times(lineMatrix)
+		textMatrix = lineMatrix
+	}
+	showText := func(text string) {
+		if state.font == nil {
+			return
+		}
+		x, y := textMatrix.times(state.ctm).apply(0, 0)
+		r.add("text %s %s %s %s %q", number(x), number(y), state.font.name, number(state.size), text)
+	}
+	for {
+		start := lexer.pos
+		if _, more, err := lexer.next(); err != nil {
+			return err
+		} else if !more {
+			return nil
+		}
+		lexer.pos = start
+		value, err := lexer.parseValue()
+		if err != nil {
+			return err
+		}
+		token, _ := value.(string)
+		if !isPdfOperator(token) {
+			operands = append(operands, value)
+			continue
+		}
+		switch token {
+		case "q":
+			saved = append(saved, state)
+		case "Q":
+			if len(saved) == 0 {
+				return errors.New("Q without q")
+			}
+			state, saved = saved[len(saved)-1], saved[:len(saved)-1]
+		case "cm":
+			state.ctm = matrix().times(state.ctm)
+		case "BT":
+			textMatrix, lineMatrix = identityMatrix, identityMatrix
+		case "Tf":
+			name, _ := operands[0].(string)
+			if state.font, err = r.font(resources, name); err != nil {
+				return err
+			}
+			state.size = operand(1)
+		case "TL":
+			leading = operand(0)
+		case "Td":
+			moveText(operand(0), operand(1))
+		case "TD":
+			leading = -operand(1)
+			moveText(operand(0), operand(1))
+		case "Tm":
+			lineMatrix = matrix()
+			textMatrix = lineMatrix
+		case "T*":
+			moveText(0, -leading)
+		case "Tj", "'", "\"":
+			if token != "Tj" {
+				moveText(0, -leading)
+			}
+			if len(operands) > 0 && state.font != nil {
+				text, _ := operands[len(operands)-1].(string)
+				showText(state.font.decode(pdfStringBytes(text)))
+			}
+		case "TJ":
+			if len(operands) > 0 && state.font != nil {
+				items, _ := operands[0].([]any)
+				var sb strings.Builder
+				for _, item := range items {
+					if text, ok := item.(string); ok && (strings.HasPrefix(text, "(") || strings.HasPrefix(text, "<")) {
+						sb.WriteString(state.font.decode(pdfStringBytes(text)))
+					}
+				}
+				showText(sb.String())
+			}
+		case "Do":
+			if err = r.drawObject(operands, resources, state.ctm); err != nil {
+				return err
+			}
+		case "m":
+			currentX, currentY = state.ctm.apply(operand(0), operand(1))
+		case "l":
+			x, y := state.ctm.apply(operand(0), operand(1))
+			path = append(path, fmt.Sprintf("line %s %s %s %s", number(currentX), number(currentY), number(x), number(y)))
+			currentX, currentY = x, y
+		case "re":
+			x, y := state.ctm.apply(operand(0), operand(1))
+			w, h := operand(2)*state.ctm[0], operand(3)*state.ctm[3]
+			path = append(path, fmt.Sprintf("rect %s %s %s %s", number(x), number(y), number(w), number(h)))
+		case "S", "s", "f", "F", "f*", "B", "B*", "b", "b*":
+			for _, item := range path {
+				r.add("%s %s", item, token)
+			}
+			path = nil
+		case "n":
+			path = nil
+		}
+		operands = nil
+	}
+}
+
+// drawObject places an image, or interprets a form such as an imported pdf page
+func (r *layoutReader) drawObject(operands []any, resources pdfDict, ctm pdfMatrix) error {
+	if len(operands) == 0 {
+		return errors.New("Do without a name")
+	}
+	xObjects, err := r.dict(resources["/XObject"])
+	if err != nil {
+		return err
+	}
+	name, _ := operands[0].(string)
+	reference, found := xObjects[name]
+	if !found {
+		return fmt.Errorf("unknown XObject %s", name)
+	}
+	dict, err := r.dict(reference)
+	if err != nil {
+		return err
+	}
+	switch dict["/Subtype"] {
+	case "/Image":
+		x, y := ctm.apply(0, 0)
+		r.add("image %s %s %s %s %sx%s", number(x), number(y), number(ctm[0]), number(ctm[3]), dict["/Width"], dict["/Height"])
+	case "/Form":
+		formMatrix := identityMatrix
+		if _, found := dict["/Matrix"]; found {
+			values, err := r.numbers(dict["/Matrix"])
+			if err != nil || len(values) != 6 {
+				return fmt.Errorf("form %s has an invalid Matrix", name)
+			}
+			copy(formMatrix[:], values)
+		}
+		formResources := resources
+		if _, found := dict["/Resources"]; found {
+			if formResources, err = r.dict(dict["/Resources"]); err != nil {
+				return err
+			}
+		}
+		content, err := r.stream(reference)
+		if err != nil {
+			return err
+		}
+		return r.readContent(content, formResources, formMatrix.times(ctm))
+	}
+	return nil
+}
+
+// assertLayout compares the layout of the pdf file against the expected one, or records it with -update
+func assertLayout(t *testing.T, pdfFile string, expectFile string) bool {
+	data, err := os.ReadFile(pdfFile)
+	if err != nil {
+		t.Errorf("Could not read %s: %s", pdfFile, err)
+		return false
+	}
+	got, err := pdfLayout(data)
+	if err != nil {
+		t.Errorf("Could not read the layout of %s: %s", pdfFile, err)
+		return false
+	}
+	if *update {
+		if err = os.WriteFile(expectFile, []byte(got), 0644); err != nil {
+			t.Errorf("Could not update %s: %s", expectFile, err)
+			return false
+		}
+		return true
+	}
+	expected, err := os.ReadFile(expectFile)
+	if err != nil {
+		t.Errorf("Could not read %s, run go test -run TestPdfCreation -update to create it: %s", expectFile, err)
+		return false
+	}
+	if string(expected) == got {
+		return true
+	}
+	wantLines := strings.Split(string(expected), "\n")
+	gotLines := strings.Split(got, "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var want, have string
+		if i < len(wantLines) {
+			want = wantLines[i]
+		}
+		if i < len(gotLines) {
+			have = gotLines[i]
+		}
+		if want != have {
+			t.Errorf("Layout of %s differs from %s in line %d:\n- %s\n+ %s\nIf the change is intended, run go test -run TestPdfCreation -update", pdfFile, expectFile, i+1, want, have)
+			break
+		}
+	}
+	return false
+}
+
+func TestPdfLayout(t *testing.T) {
+	content := "q 0.5 0 0 0.5 10 20 cm BT /F1 12.00 Tf 100 200 Td (Gr\\374\\337e)Tj ET Q " +
+		"BT /F2 7.00 Tf 8 TL 5 6 Td <004300410042>Tj T* [(\\000A) -120 (\\000B)]TJ ET " +
+		"0 0 m 50 0 l S 1 2 3 4 re f q 30 0 0 40 15 25 cm /I1 Do Q"
+	cmap := "1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"1 beginbfchar <0041> <00C4> endbfchar 1 beginbfrange <0042> <0043> <0061> endbfrange"
+	data := fmt.Sprintf("%%PDF-1.3\n"+
+		"1 0 obj\n<</Type /Pages /Kids [3 0 R] /MediaBox [0 0 200 100]>>\nendobj\n"+
+		"2 0 obj\n<</Font <</F1 4 0 R /F2 5 0 R>> /XObject <</I1 7 0 R>>>>\nendobj\n"+
+		"3 0 obj\n<</Type /Page /Parent 1 0 R /Resources 2 0 R /Contents 8 0 R>>\nendobj\n"+
+		"4 0 obj\n<</Type /Font /Subtype /Type1 /BaseFont /Helvetica>>\nendobj\n"+
+		"5 0 obj\n<</Type /Font /Subtype /Type0 /BaseFont /ABCDEF+Noto /ToUnicode 6 0 R>>\nendobj\n"+
+		"6 0 obj\n<</Length %d>>\nstream\n%s\nendstream\nendobj\n"+
+		"7 0 obj\n<</Type /XObject /Subtype /Image /Width 3 /Height 4 /Length 0>>\nstream\n\nendstream\nendobj\n"+
+		"8 0 obj\n<</Length %d>>\nstream\n%s\nendstream\nendobj\n"+
+		"9 0 obj\n<</Type /Catalog /Pages 1 0 R>>\nendobj\ntrailer\n<</Root 9 0 R>>\n", len(cmap), cmap, len(content), content)
+	layout, err := pdfLayout([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertStringSliceEquals(t, strings.Split(strings.TrimSpace(layout), "\n"), []string{
+		"page 1 200.00 100.00",
+		`text 60.00 120.00 Helvetica 12.00 "Grüße"`,
+		`text 5.00 6.00 Noto 7.00 "bÄa"`,
+		`text 5.00 -2.00 Noto 7.00 "Äa"`,
+		"line 0.00 0.00 50.00 0.00 S",
+		"rect 1.00 2.00 3.00 4.00 f",
+		"image 15.00 25.00 30.00 40.00 3x4",
+	}, "layout")
+}
@@ -0,0 +1,393 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfObject is an indirect object of a pdf file as written by fpdf
+type pdfObject struct {
+	Number int
+	// Size is the number of bytes the object takes up in the file
+	Size int
+	// Dict is the source of the object up to its stream, if it has one
+	Dict   string
+	Stream []byte
+}
+
+var (
+	pdfObjectStart = regexp.MustCompile(`(?m)^(\d+) (\d+) obj\s*`)
+	pdfStreamStart = regexp.MustCompile(`stream\r?\n`)
+	pdfReference   = regexp.MustCompile(`(\d+) \d+ R\b`)
+	pdfLength      = regexp.MustCompile(`/Length (\d+)\b(\s+\d+\s+R\b)?`)
+)
+
+// readPdfObjects reads the indirect objects of a pdf, streams are skipped using their /Length. If an object is
+// defined more than once, e.g. by an incremental update, the last definition wins.
+func readPdfObjects(data []byte) (map[int]pdfObject, error) {
+	objects := map[int]pdfObject{}
+	for pos := 0; ; {
+		start := pdfObjectStart.FindSubmatchIndex(data[pos:])
+		if start == nil {
+			break
+		}
+		number, _ := strconv.Atoi(string(data[pos+start[2] : pos+start[3]]))
+		bodyStart := pos + start[1]
+		end := bytes.Index(data[bodyStart:], []byte("endobj"))
+		if end < 0 {
+			return nil, fmt.Errorf("object %d is not terminated", number)
+		}
+		object := pdfObject{Number: number, Dict: string(data[bodyStart : bodyStart+end])}
+		if stream := pdfStreamStart.FindIndex(data[bodyStart : bodyStart+end]); stream != nil {
+			object.Dict = string(data[bodyStart : bodyStart+stream[0]])
+			length := pdfLength.FindStringSubmatch(object.Dict)
+			if length == nil {
+				return nil, fmt.Errorf("object %d has a stream without /Length", number)
+			}
+			size, _ := strconv.Atoi(length[1])
+			streamStart := bodyStart + stream[1]
+			if length[2] != "" {
+				// the length is an indirect object, imported pdf pages may have those
+				size = bytes.Index(data[streamStart:], []byte("endstream"))
+				if size < 0 {
+					return nil, fmt.Errorf("object %d has an unterminated stream", number)
+				}
+				if bytes.HasSuffix(data[:streamStart+size], []byte("\r\n")) {
+					size -= 2
+				} else if size > 0 && (data[streamStart+size-1] == '\n' || data[streamStart+size-1] == '\r') {
+					size--
+				}
+			}
+			if streamStart+size > len(data) {
+				return nil, fmt.Errorf("stream of object %d exceeds the file", number)
+			}
+			object.Stream = data[streamStart : streamStart+size]
+			end = bytes.Index(data[streamStart+size:], []byte("endobj"))
+			if end < 0 {
+				return nil, fmt.Errorf("object %d is not terminated", number)
+			}
+			end += streamStart + size - bodyStart
+		}
+		objectStart := pos + start[0]
+		pos = bodyStart + end + len("endobj")
+		object.Size = pos - objectStart
+		objects[number] = object
+	}
+	if len(objects) == 0 {
+		return nil, errors.New("no pdf objects found")
+	}
+	return objects, nil
+}
+
+// references returns the numbers of the objects referenced by the dictionary of o
+func (o pdfObject) references() []int {
+	var numbers []int
+	for _, match := range pdfReference.FindAllStringSubmatch(o.Dict, -1) {
+		number, _ := strconv.Atoi(match[1])
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+// name returns the value of the name entry key of the dictionary of o, e.g. "Font" for "Type"
+func (o pdfObject) name(key string) string {
+	match := regexp.MustCompile(`/` + key + `\s*/([^\s/<>\[\]()]+)`).FindStringSubmatch(o.Dict)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// decodedStream returns the stream of o, inflated if it is compressed
+func (o pdfObject) decodedStream() ([]byte, error) {
+	if o.name("Filter") != "FlateDecode" {
+		return o.Stream, nil
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(o.Stream))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// sortedNumbers returns the numbers of objects in ascending order
+func sortedNumbers(objects map[int]pdfObject) []int {
+	numbers := make([]int, 0, len(objects))
+	for number := range objects {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// reachable returns the numbers of all objects that can be reached from the given one, including itself
+func reachable(objects map[int]pdfObject, number int) []int {
+	seen := map[int]bool{}
+	pending := []int{number}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		object, found := objects[current]
+		if seen[current] || !found {
+			continue
+		}
+		seen[current] = true
+		pending = append(pending, object.references()...)
+	}
+	numbers := make([]int, 0, len(seen))
+	for n := range seen {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+var (
+	pdfTrailerRoot = regexp.MustCompile(`/Root (\d+) \d+ R`)
+	pdfTrailerInfo = regexp.MustCompile(`/Info (\d+) \d+ R`)
+)
+
+// readPdfTrailer returns the object numbers of the catalog and the info dictionary given by the last trailer
+func readPdfTrailer(data []byte) (int, int, error) {
+	start := bytes.LastIndex(data, []byte("trailer"))
+	if start < 0 {
+		return 0, 0, errors.New("no trailer found")
+	}
+	trailer := data[start:]
+	root := pdfTrailerRoot.FindSubmatch(trailer)
+	if root == nil {
+		return 0, 0, errors.New("the trailer has no /Root")
+	}
+	rootNumber, _ := strconv.Atoi(string(root[1]))
+	infoNumber := 0
+	if info := pdfTrailerInfo.FindSubmatch(trailer); info != nil {
+		infoNumber, _ = strconv.Atoi(string(info[1]))
+	}
+	return rootNumber, infoNumber, nil
+}
+
+// stringEntry returns the decoded value of the string entry key of the dictionary of o
+func (o pdfObject) stringEntry(key string) (string, bool) {
+	start := regexp.MustCompile(`/` + key + `\s*\(`).FindStringIndex(o.Dict)
+	if start == nil {
+		return "", false
+	}
+	return parsePdfString(o.Dict[start[1]-1:]), true
+}
+
+// parsePdfString decodes the literal string at the start of s, e.g. "(Hello \(World\))". Strings starting with a
+// UTF-16BE byte order mark are converted to utf-8.
+func parsePdfString(s string) string {
+	return decodePdfText(unescapePdfString(s))
+}
+
+// unescapePdfString returns the bytes of the literal string at the start of s with its escapes resolved
+func unescapePdfString(s string) []byte {
+	var result []byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return result
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// line continuation
+				if s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+				continue
+			default:
+				if s[i] >= '0' && s[i] <= '7' {
+					value := 0
+					for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; j++ {
+						value = value*8 + int(s[i]-'0')
+						i++
+					}
+					i--
+					c = byte(value)
+				} else {
+					c = s[i]
+				}
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func decodePdfText(text []byte) string {
+	if len(text) < 2 || text[0] != 0xFE || text[1] != 0xFF {
+		return string(text)
+	}
+	units := make([]uint16, 0, len(text)/2)
+	for i := 2; i+1 < len(text); i += 2 {
+		units = append(units, uint16(text[i])<<8|uint16(text[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// writePdfObjects writes a complete pdf consisting of the given objects. The trailer refers to root, info (if not 0)
+// and carries the given document id.
+func writePdfObjects(version string, objects map[int]pdfObject, root int, info int, id []byte) []byte {
+	var out bytes.Buffer
+	// the comment with bytes above 127 marks the file as binary
+	out.WriteString("%PDF-" + version + "\n%\xe2\xe3\xcf\xd3\n")
+	size := 0
+	for number := range objects {
+		if number >= size {
+			size = number + 1
+		}
+	}
+	offsets := make([]int, size)
+	for number := 1; number < size; number++ {
+		object, ok := objects[number]
+		if !ok {
+			continue
+		}
+		offsets[number] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\n", number, strings.TrimSpace(object.Dict))
+		if object.Stream != nil {
+			out.WriteString("stream\n")
+			out.Write(object.Stream)
+			out.WriteString("\nendstream\n")
+		}
+		out.WriteString("endobj\n")
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", size)
+	for number := 1; number < size; number++ {
+		if offsets[number] == 0 {
+			out.WriteString("0000000000 65535 f \n")
+		} else {
+			fmt.Fprintf(&out, "%010d 00000 n \n", offsets[number])
+		}
+	}
+	fmt.Fprintf(&out, "trailer\n<<\n/Size %d\n/Root %d 0 R\n", size, root)
+	if info != 0 {
+		fmt.Fprintf(&out, "/Info %d 0 R\n", info)
+	}
+	if id != nil {
+		fmt.Fprintf(&out, "/ID [<%X> <%X>]\n", id, id)
+	}
+	fmt.Fprintf(&out, ">>\nstartxref\n%d\n%%%%EOF\n", xref)
+	return out.Bytes()
+}
+
+// withEntries returns the dictionary dict with the given entries added at its end
+func withEntries(dict string, entries ...string) string {
+	end := strings.LastIndex(dict, ">>")
+	if end < 0 {
+		return dict
+	}
+	return strings.TrimRight(dict[:end], " \r\n") + "\n" + strings.Join(entries, "\n") + "\n" + dict[end:]
+}
+
+// renumberPdfObjects numbers the objects in the order they are reached from the catalog and the info dictionary,
+// followed by unreachable ones, so that the numbering no longer depends on the order in which they were written
+func renumberPdfObjects(objects map[int]pdfObject, root int, info int) (map[int]pdfObject, int, int) {
+	numbers := map[int]int{}
+	var visit func(int)
+	visit = func(number int) {
+		object, ok := objects[number]
+		if _, seen := numbers[number]; seen || !ok {
+			return
+		}
+		numbers[number] = len(numbers) + 1
+		for _, reference := range object.references() {
+			visit(reference)
+		}
+	}
+	visit(root)
+	visit(info)
+	for _, number := range sortedNumbers(objects) {
+		visit(number)
+	}
+	renumbered := make(map[int]pdfObject, len(objects))
+	for number, object := range objects {
+		object.Number = numbers[number]
+		object.Dict = pdfReference.ReplaceAllStringFunc(object.Dict, func(reference string) string {
+			referenced, _ := strconv.Atoi(pdfReference.FindStringSubmatch(reference)[1])
+			if renumberedReference, ok := numbers[referenced]; ok {
+				return strconv.Itoa(renumberedReference) + " 0 R"
+			}
+			return reference
+		})
+		renumbered[object.Number] = object
+	}
+	return renumbered, numbers[root], numbers[info]
+}
+
+// normalizePdf rewrites a pdf with its dictionaries sorted, its objects renumbered by renumberPdfObjects and a document
+// id derived from its content, so that the same content always yields the same file
+func normalizePdf(data []byte) ([]byte, error) {
+	version := pdfVersion.FindSubmatch(data)
+	if version == nil {
+		return nil, errors.New("no pdf header found")
+	}
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		return nil, err
+	}
+	root, info, err := readPdfTrailer(data)
+	if err != nil {
+		return nil, err
+	}
+	// the numbering depends on the order of the references, which has to be fixed first
+	for number, object := range objects {
+		object.Dict = canonicalPdfValue(object.Dict)
+		objects[number] = object
+	}
+	objects, root, info = renumberPdfObjects(objects, root, info)
+	id := md5.Sum(writePdfObjects(string(version[1]), objects, root, info, nil))
+	return writePdfObjects(string(version[1]), objects, root, info, id[:]), nil
+}
@@ -0,0 +1,80 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParsePdfString(t *testing.T) {
+	AssertEquals(t, parsePdfString(`(Hello \(escaped\) and (balanced)) tail`), "Hello (escaped) and (balanced)", "parentheses")
+	AssertEquals(t, parsePdfString(`(line\nbreak\101\\)`), "line\nbreakA\\", "escape sequences")
+	AssertEquals(t, parsePdfString("(\xfe\xff\x00l\x00e\x00f\x00t\x00 \x20\xac)"), "left €", "utf-16")
+}
+
+func TestReadPdfObjects(t *testing.T) {
+	data := []byte("%PDF-1.3\n1 0 obj\n<</Type /Font /BaseFont /Helvetica>>\nendobj\n" +
+		"2 0 obj\n<</Length 14>>\nstream\n3 0 obj endobj\nendstream\nendobj\n" +
+		"1 0 obj\n<</Type /Font /BaseFont /Courier /Next 2 0 R>>\nendobj\n")
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, len(objects), 2, "objects, the stream content is skipped")
+	AssertEquals(t, objects[1].name("BaseFont"), "Courier", "last definition wins")
+	AssertEquals(t, string(objects[2].Stream), "3 0 obj endobj", "stream")
+	AssertEquals(t, fmt.Sprint(reachable(objects, 1)), "[1 2]", "reachable")
+	_, err = readPdfObjects([]byte("not a pdf"))
+	AssertEquals(t, err.Error(), "no pdf objects found", "error")
+}
+
+func TestCanonicalPdfValue(t *testing.T) {
+	AssertEquals(t, canonicalPdfValue("<</Type /Page\n/Resources <</XObject <</I1 12 0 R>> /Font<</F1 3 0 R>>>> /Kids [1 0 R  2 0 R]>>"),
+		"<< /Kids [1 0 R 2 0 R] /Resources << /Font << /F1 3 0 R >> /XObject << /I1 12 0 R >> >> /Type /Page >>", "sorted")
+	AssertEquals(t, canonicalPdfValue("<</T (a >> (b) \\) c) /H <48656c6c6f>>>"), "<< /H <48656c6c6f> /T (a >> (b) \\) c) >>", "strings")
+	AssertEquals(t, canonicalPdfValue("<</Broken"), "<</Broken", "unparsable")
+	AssertEquals(t, canonicalPdfValue(" 42 "), "42", "number")
+}
+
+func TestNormalizePdf(t *testing.T) {
+	// the same objects written in a different order
+	first := []byte("%PDF-1.3\n1 0 obj\n<</Type /Pages /Kids [3 0 R]>>\nendobj\n2 0 obj\n<</Producer (x)>>\nendobj\n" +
+		"3 0 obj\n<</Type /Page /Parent 1 0 R>>\nendobj\n4 0 obj\n<</Type /Catalog /Pages 1 0 R>>\nendobj\n" +
+		"trailer\n<</Size 5 /Root 4 0 R /Info 2 0 R>>\n")
+	second := []byte("%PDF-1.3\n1 0 obj\n<</Parent 4 0 R /Type /Page>>\nendobj\n2 0 obj\n<</Pages 4 0 R /Type /Catalog>>\nendobj\n" +
+		"3 0 obj\n<</Producer (x)>>\nendobj\n4 0 obj\n<</Kids [1 0 R] /Type /Pages>>\nendobj\n" +
+		"trailer\n<</Size 5 /Root 2 0 R /Info 3 0 R>>\n")
+	normalizedFirst, err := normalizePdf(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalizedSecond, err := normalizePdf(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, string(normalizedFirst), string(normalizedSecond), "normalized")
+	objects, err := readPdfObjects(normalizedFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, strings.TrimSpace(objects[1].Dict), "<< /Pages 2 0 R /Type /Catalog >>", "catalog first")
+}
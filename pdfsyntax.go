@@ -0,0 +1,218 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pdfLexer splits pdf syntax, e.g. an object or a content stream, into tokens: the delimiters "<<", ">>", "[", "]",
+// "{" and "}", strings including their delimiters, names including the leading slash, and runs of regular
+// characters such as numbers, keywords and operators.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPdfWhitespace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPdfDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// next returns the next token, or false at the end of the data
+func (l *pdfLexer) next() (string, bool, error) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isPdfWhitespace(c) {
+			l.pos++
+		} else if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		} else {
+			break
+		}
+	}
+	if l.pos >= len(l.data) {
+		return "", false, nil
+	}
+	start := l.pos
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		depth := 0
+		for ; l.pos < len(l.data); l.pos++ {
+			switch l.data[l.pos] {
+			case '\\':
+				l.pos++
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if depth == 0 {
+				l.pos++
+				return string(l.data[start:l.pos]), true, nil
+			}
+		}
+		return "", false, errors.New("unterminated string")
+	case c == '<' || c == '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == c {
+			l.pos += 2
+			return string(l.data[start:l.pos]), true, nil
+		}
+		if c == '>' {
+			return "", false, fmt.Errorf("unexpected > at %d", l.pos)
+		}
+		end := strings.IndexByte(string(l.data[l.pos:]), '>')
+		if end < 0 {
+			return "", false, errors.New("unterminated hex string")
+		}
+		l.pos += end + 1
+		return string(l.data[start:l.pos]), true, nil
+	case strings.IndexByte("[]{}", c) >= 0:
+		l.pos++
+		return string(c), true, nil
+	}
+	l.pos++
+	for l.pos < len(l.data) && !isPdfWhitespace(l.data[l.pos]) && !isPdfDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos]), true, nil
+}
+
+// pdfDict is a dictionary of parsed pdf values: tokens, []any for arrays and pdfDict
+type pdfDict map[string]any
+
+func isPdfInteger(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue parses the value starting with the next token. References such as "12 0 R" are a single token.
+func (l *pdfLexer) parseValue() (any, error) {
+	token, ok, err := l.next()
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("unexpected end")
+	}
+	switch token {
+	case "<<":
+		dict := pdfDict{}
+		for {
+			key, ok, err := l.next()
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				return nil, errors.New("unterminated dictionary")
+			} else if key == ">>" {
+				return dict, nil
+			} else if !strings.HasPrefix(key, "/") {
+				return nil, fmt.Errorf("dictionary key %s is no name", key)
+			}
+			if dict[key], err = l.parseValue(); err != nil {
+				return nil, err
+			}
+		}
+	case "[":
+		array := []any{}
+		for {
+			start := l.pos
+			token, ok, err := l.next()
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				return nil, errors.New("unterminated array")
+			} else if token == "]" {
+				return array, nil
+			}
+			l.pos = start
+			value, err := l.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+	}
+	if isPdfInteger(token) {
+		start := l.pos
+		generation, _, _ := l.next()
+		keyword, _, _ := l.next()
+		if isPdfInteger(generation) && keyword == "R" {
+			return token + " " + generation + " R", nil
+		}
+		l.pos = start
+	}
+	return token, nil
+}
+
+// formatPdfValue writes a parsed value back, dictionaries with their keys in sorted order
+func formatPdfValue(value any) string {
+	switch v := value.(type) {
+	case pdfDict:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var sb strings.Builder
+		sb.WriteString("<<")
+		for _, key := range keys {
+			sb.WriteString(" " + key + " " + formatPdfValue(v[key]))
+		}
+		sb.WriteString(" >>")
+		return sb.String()
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatPdfValue(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	return value.(string)
+}
+
+// canonicalPdfValue returns source, a single pdf value, with its dictionaries sorted and its whitespace normalized.
+// Anything that cannot be parsed is returned as is.
+func canonicalPdfValue(source string) string {
+	lexer := &pdfLexer{data: []byte(source)}
+	value, err := lexer.parseValue()
+	if err != nil {
+		return source
+	}
+	if _, more, _ := lexer.next(); more {
+		return source
+	}
+	return formatPdfValue(value)
+}
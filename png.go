@@ -0,0 +1,166 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/vector"
+)
+
+// defaultDPI is the resolution of png pages unless -dpi says otherwise
+const defaultDPI = 150
+
+// maxPagePixels limits the size of a png page, about an A4 page at 600 dpi, so that a typo in -dpi does not take all
+// the memory
+const maxPagePixels = 40000000
+
+// renderPng writes every page of the letter as a png image, e.g. for thumbnails or previews
+func renderPng(inputFile string, defaultConfig Config, options Options) ([]Warning, error) {
+	dpi := options.DPI
+	if dpi == 0 {
+		dpi = defaultDPI
+	}
+	letter, err := layoutPages(inputFile, defaultConfig, options)
+	if err != nil {
+		return nil, err
+	}
+	for i, page := range letter.layout.Pages {
+		canvas, err := letter.rasterize(page, dpi)
+		if err != nil {
+			return nil, err
+		}
+		var buffer bytes.Buffer
+		if err = png.Encode(&buffer, canvas); err != nil {
+			return nil, err
+		}
+		if err = writeFileAtomic(pageFileName(inputFile, "png", i+1), buffer.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if err = removeStalePages(inputFile, "png", len(letter.layout.Pages)); err != nil {
+		return nil, err
+	}
+	return letter.layout.Warnings, nil
+}
+
+// rasterize draws a page black on white at the given resolution
+func (l pageLetter) rasterize(page layoutPage, dpi float64) (*image.RGBA, error) {
+	pixels := func(mm float64) float64 {
+		return mm * dpi / 25.4
+	}
+	width, height := math.Round(pixels(l.width)), math.Round(pixels(l.height))
+	if width*height > maxPagePixels {
+		return nil, fmt.Errorf("at %g dpi a page has %gx%g pixels, more than the %d million left draws, choose a lower -dpi",
+			dpi, width, height, maxPagePixels/1000000)
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	for _, item := range page.Items {
+		switch item := item.(type) {
+		case layoutText:
+			for _, run := range item.Runs {
+				path, err := l.glyphs.outline(item, run)
+				if err != nil {
+					return nil, err
+				}
+				fillPath(canvas, path, pixels)
+			}
+		case layoutImage:
+			source, err := decodeImageFile(item.File)
+			if err != nil {
+				return nil, err
+			}
+			target := image.Rect(int(math.Round(pixels(item.X))), int(math.Round(pixels(item.Y))),
+				int(math.Round(pixels(item.X+item.Width))), int(math.Round(pixels(item.Y+item.Height))))
+			xdraw.CatmullRom.Scale(canvas, target, source, source.Bounds(), draw.Over, nil)
+		case layoutRule:
+			fillPath(canvas, rulePath(item), pixels)
+		}
+	}
+	return canvas, nil
+}
+
+// fillPath fills a path black, pixels converts its coordinates from mm. Only the bounds of the path are rasterized.
+func fillPath(canvas *image.RGBA, path []pathSegment, pixels func(mm float64) float64) {
+	if len(path) == 0 {
+		return
+	}
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, segment := range path {
+		for _, point := range segment.Points {
+			x, y := pixels(point[0]), pixels(point[1])
+			minX, minY, maxX, maxY = math.Min(minX, x), math.Min(minY, y), math.Max(maxX, x), math.Max(maxY, y)
+		}
+	}
+	bounds := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+	if bounds.Empty() || !bounds.Overlaps(canvas.Bounds()) {
+		return
+	}
+	rasterizer := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	point := func(p [2]float64) (float32, float32) {
+		return float32(pixels(p[0]) - float64(bounds.Min.X)), float32(pixels(p[1]) - float64(bounds.Min.Y))
+	}
+	for _, segment := range path {
+		switch segment.Op {
+		case sfnt.SegmentOpMoveTo:
+			rasterizer.MoveTo(point(segment.Points[0]))
+		case sfnt.SegmentOpLineTo:
+			rasterizer.LineTo(point(segment.Points[0]))
+		case sfnt.SegmentOpQuadTo:
+			x1, y1 := point(segment.Points[0])
+			x2, y2 := point(segment.Points[1])
+			rasterizer.QuadTo(x1, y1, x2, y2)
+		case sfnt.SegmentOpCubeTo:
+			x1, y1 := point(segment.Points[0])
+			x2, y2 := point(segment.Points[1])
+			x3, y3 := point(segment.Points[2])
+			rasterizer.CubeTo(x1, y1, x2, y2, x3, y3)
+		}
+	}
+	rasterizer.ClosePath()
+	// the rasterizer does not clip what it draws, so a path that is partly off the page is drawn into a mask of its
+	// own, and only the part of the mask on the page is drawn onto it
+	mask := image.NewAlpha(bounds)
+	rasterizer.Draw(mask, bounds, image.Opaque, image.Point{})
+	clipped := bounds.Intersect(canvas.Bounds())
+	draw.DrawMask(canvas, clipped, image.Black, image.Point{}, mask, clipped.Min, draw.Over)
+}
+
+// decodeImageFile reads an image, such as the signature
+func decodeImageFile(file string) (image.Image, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, describeFileError(file, err)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
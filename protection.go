@@ -0,0 +1,105 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	userPasswordVariable  = "LEFT_USER_PASSWORD"
+	ownerPasswordVariable = "LEFT_OWNER_PASSWORD"
+)
+
+var permissionFlags = map[string]byte{
+	"print":  fpdf.CnProtectPrint,
+	"copy":   fpdf.CnProtectCopy,
+	"modify": fpdf.CnProtectModify,
+}
+
+// pdfProtection is a Protection with its passwords read
+type pdfProtection struct {
+	userPassword  string
+	ownerPassword string
+	permissions   byte
+}
+
+// resolveProtection applies the protection flags on top of the Protection config field and reads the passwords. It
+// returns nil if the pdf is not to be protected.
+func resolveProtection(config Config, options Options) (*pdfProtection, error) {
+	var protection Protection
+	if config.Protection != nil {
+		protection = *config.Protection
+	} else if options.UserPasswordFile == "" && options.OwnerPasswordFile == "" && options.Permissions == "" {
+		return nil, nil
+	}
+	if options.UserPasswordFile != "" {
+		protection.UserPasswordFile = options.UserPasswordFile
+	}
+	if options.OwnerPasswordFile != "" {
+		protection.OwnerPasswordFile = options.OwnerPasswordFile
+	}
+	if options.Permissions == "none" {
+		protection.Permissions = nil
+	} else if options.Permissions != "" {
+		protection.Permissions = strings.Split(options.Permissions, ",")
+	}
+
+	result := &pdfProtection{}
+	var err error
+	if result.userPassword, err = readPassword(userPasswordVariable, protection.UserPasswordFile, "Protection.UserPasswordFile"); err != nil {
+		return nil, err
+	}
+	if result.ownerPassword, err = readPassword(ownerPasswordVariable, protection.OwnerPasswordFile, "Protection.OwnerPasswordFile"); err != nil {
+		return nil, err
+	}
+	if result.userPassword == "" && result.ownerPassword == "" {
+		return nil, &configError{Field: "Protection", Err: fmt.Errorf("neither a user nor an owner password is given, "+
+			"set UserPasswordFile or %s and OwnerPasswordFile or %s", userPasswordVariable, ownerPasswordVariable)}
+	}
+	for _, permission := range protection.Permissions {
+		flag, ok := permissionFlags[strings.ToLower(strings.TrimSpace(permission))]
+		if !ok {
+			return nil, &configError{Field: "Protection.Permissions", Err: fmt.Errorf("%q is neither \"print\", \"copy\" nor \"modify\"", permission)}
+		}
+		result.permissions |= flag
+	}
+	return result, nil
+}
+
+// readPassword returns the value of the environment variable if it is set, otherwise the first line of fileName
+func readPassword(variable string, fileName string, field string) (string, error) {
+	if password, ok := os.LookupEnv(variable); ok {
+		return password, nil
+	}
+	if fileName == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", &configError{Field: field, Err: describeFileError(fileName, err)}
+	}
+	password, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSuffix(password, "\r"), nil
+}
@@ -0,0 +1,133 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// unsetEnv removes an environment variable for the duration of a test
+func unsetEnv(t *testing.T, variable string) {
+	t.Setenv(variable, "")
+	if err := os.Unsetenv(variable); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// opensWith tells whether password is the user password of a pdf encrypted with revision 2 of the standard
+// security handler, the one fpdf uses
+func opensWith(t *testing.T, encrypt pdfObject, password string) bool {
+	padding := []byte{
+		0x28, 0xBF, 0x4E, 0x5E, 0x4E, 0x75, 0x8A, 0x41, 0x64, 0x00, 0x4E, 0x56, 0xFF, 0xFA, 0x01, 0x08,
+		0x2E, 0x2E, 0x00, 0xB6, 0xD0, 0x68, 0x3E, 0x80, 0x2F, 0x0C, 0xA9, 0xFE, 0x64, 0x53, 0x69, 0x7A,
+	}
+	owner, _ := encrypt.stringEntry("O")
+	user, _ := encrypt.stringEntry("U")
+	permissions, _ := strconv.Atoi(regexp.MustCompile(`/P (-?\d+)`).FindStringSubmatch(encrypt.Dict)[1])
+	input := append([]byte(password), padding...)[:32]
+	input = append(input, owner...)
+	input = binary.LittleEndian.AppendUint32(input, uint32(int32(permissions)))
+	key := md5.Sum(input)
+	cipher, err := rc4.NewCipher(key[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, 32)
+	cipher.XORKeyStream(expected, padding)
+	return bytes.Equal(expected, []byte(user))
+}
+
+func TestProtection(t *testing.T) {
+	dir := t.TempDir()
+	ownerPasswordFile := filepath.Join(dir, "owner.txt")
+	if err := os.WriteFile(ownerPasswordFile, []byte("owner secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(userPasswordVariable, "geheim")
+	unsetEnv(t, ownerPasswordVariable)
+	letter := letterWithConfig(`{"Protection": {"OwnerPasswordFile": "` + ownerPasswordFile + `", "Permissions": ["print"]}}`)
+	data := renderPdf(t, letter, Options{})
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trailer := data[bytes.LastIndex(data, []byte("trailer")):]
+	encryptRef := regexp.MustCompile(`/Encrypt (\d+) 0 R`).FindSubmatch(trailer)
+	if encryptRef == nil {
+		t.Fatal("the pdf is not encrypted")
+	}
+	number, _ := strconv.Atoi(string(encryptRef[1]))
+	encrypt := objects[number]
+	if !regexp.MustCompile(`/P -60\b`).MatchString(encrypt.Dict) {
+		t.Errorf("only printing should be allowed: %s", encrypt.Dict)
+	}
+	AssertEquals(t, opensWith(t, encrypt, "geheim"), true, "user password")
+	AssertEquals(t, opensWith(t, encrypt, "owner secret"), false, "wrong password")
+
+	// the flags take precedence over the config
+	data = renderPdf(t, letter, Options{Permissions: "copy,modify"})
+	if !regexp.MustCompile(`/P -40\b`).Match(data) {
+		t.Error("copying and modifying should be allowed")
+	}
+}
+
+func TestProtectionErrors(t *testing.T) {
+	inputFile := writeTestLetter(t, minimalLetter)
+	write := func(config string) {
+		if err := os.WriteFile(inputFile, []byte(letterWithConfig(config)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unsetEnv(t, userPasswordVariable)
+	unsetEnv(t, ownerPasswordVariable)
+	write(`{"Protection": {"Permissions": ["print"]}}`)
+	_, err := render(inputFile, defaultConfig, Options{})
+	AssertEquals(t, err.Error(), "Protection: neither a user nor an owner password is given, set UserPasswordFile or LEFT_USER_PASSWORD "+
+		"and OwnerPasswordFile or LEFT_OWNER_PASSWORD", "no password")
+	_, err = render(inputFile, defaultConfig, Options{UserPasswordFile: "missing.txt"})
+	AssertEquals(t, err.Error(), "Protection.UserPasswordFile: missing.txt not found", "missing password file")
+
+	t.Setenv(userPasswordVariable, "geheim")
+	write(`{"Protection": {"Permissions": ["print", "fly"]}}`)
+	_, err = render(inputFile, defaultConfig, Options{})
+	AssertEquals(t, err.Error(), `Protection.Permissions: "fly" is neither "print", "copy" nor "modify"`, "unknown permission")
+	write(`{"Protection": {}, "Enclosures": ["contract.PDF"]}`)
+	_, err = render(inputFile, defaultConfig, Options{})
+	AssertEquals(t, err.Error(), "Protection: pdf Enclosures cannot be password protected", "pdf enclosure")
+	write(`{"Protection": {}}`)
+	_, err = render(inputFile, defaultConfig, Options{PDFA: "2b"})
+	AssertEquals(t, err.Error(), "Protection: PDF/A does not allow encryption", "PDF/A")
+
+	// the source of a protected pdf cannot be read without its password
+	_, err = render(inputFile, defaultConfig, Options{EmbedSource: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = extractSource(outputFileName(inputFile))
+	AssertEquals(t, err.Error(), outputFileName(inputFile)+" is password protected, its letter source cannot be extracted", "extract")
+}
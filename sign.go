@@ -0,0 +1,246 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// signPasswordVariable names the environment variable holding the password of a PKCS#12 keystore
+const signPasswordVariable = "LEFT_SIGN_PASSWORD"
+
+// signer is a private key together with its certificate chain, the signing certificate first
+type signer struct {
+	key   crypto.Signer
+	chain []*x509.Certificate
+}
+
+// signatureField places the signature on a page, counted from 1. Rect is the visible area in pdf points, an empty
+// one makes the signature invisible.
+type signatureField struct {
+	Page int
+	Rect [4]float64
+}
+
+// loadSigner reads the key given by -sign-key, either a PKCS#12 keystore or a PEM file, and the certificates given
+// by -sign-cert. It returns nil if no key is given.
+func loadSigner(keyFile string, certFile string) (*signer, error) {
+	if keyFile == "" {
+		if certFile != "" {
+			return nil, errors.New("-sign-cert requires -sign-key")
+		}
+		return nil, nil
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("-sign-key: %s", describeFileError(keyFile, err))
+	}
+	var key any
+	var certificates []*x509.Certificate
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		key, certificates, err = parsePem(data)
+	} else {
+		var leaf *x509.Certificate
+		var ca []*x509.Certificate
+		key, leaf, ca, err = pkcs12.DecodeChain(data, os.Getenv(signPasswordVariable))
+		certificates = append([]*x509.Certificate{leaf}, ca...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-sign-key: %s: %s", keyFile, err)
+	}
+	if certFile != "" {
+		data, err = os.ReadFile(certFile)
+		if err != nil {
+			return nil, fmt.Errorf("-sign-cert: %s", describeFileError(certFile, err))
+		}
+		var fileCertificates []*x509.Certificate
+		if bytes.Contains(data, []byte("-----BEGIN")) {
+			_, fileCertificates, err = parsePem(data)
+		} else {
+			fileCertificates, err = x509.ParseCertificates(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("-sign-cert: %s: %s", certFile, err)
+		}
+		certificates = append(fileCertificates, certificates...)
+	}
+	signingKey, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("-sign-key: %s contains no private key", keyFile)
+	}
+	for i, certificate := range certificates {
+		if publicKey, ok := signingKey.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && publicKey.Equal(certificate.PublicKey) {
+			chain := append([]*x509.Certificate{certificate}, certificates[:i]...)
+			return &signer{key: signingKey, chain: append(chain, certificates[i+1:]...)}, nil
+		}
+	}
+	return nil, fmt.Errorf("-sign-cert: no certificate matches the key in %s", keyFile)
+}
+
+// parsePem returns the private key, if any, and the certificates of a PEM file
+func parsePem(data []byte) (any, []*x509.Certificate, error) {
+	var key any
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			var certificate *x509.Certificate
+			if certificate, err = x509.ParseCertificate(block.Bytes); err == nil {
+				certificates = append(certificates, certificate)
+			}
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			err = errors.New("encrypted PEM keys are not supported, use a PKCS#12 keystore instead")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return key, certificates, nil
+}
+
+var (
+	pdfVersion  = regexp.MustCompile(`^%PDF-(\d\.\d)`)
+	pdfPagesRef = regexp.MustCompile(`/Pages (\d+) \d+ R`)
+	pdfKids     = regexp.MustCompile(`/Kids\s*\[([^\]]*)\]`)
+	pdfAnnots   = regexp.MustCompile(`/Annots\s*\[`)
+)
+
+// byteRangePlaceholder reserves the space of the /ByteRange array, which is only known once the file is written
+const byteRangePlaceholder = "[0 0000000000 0000000000 0000000000]"
+
+// signPdf adds a signature field to a pdf and signs everything but the signature itself with a detached CMS
+// signature, as PAdES describes. The pdf is rewritten like convertToPdfA does, signed is the signing time shown by
+// pdf viewers.
+func signPdf(data []byte, s signer, field signatureField, signed time.Time) ([]byte, error) {
+	version := pdfVersion.FindSubmatch(data)
+	if version == nil {
+		return nil, errors.New("no pdf header found")
+	}
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		return nil, err
+	}
+	root, info, err := readPdfTrailer(data)
+	if err != nil {
+		return nil, err
+	}
+	catalog, ok := objects[root]
+	if !ok {
+		return nil, errors.New("the catalog is missing")
+	}
+	pagesRef := pdfPagesRef.FindStringSubmatch(catalog.Dict)
+	if pagesRef == nil {
+		return nil, errors.New("the catalog has no pages")
+	}
+	pagesNumber, _ := strconv.Atoi(pagesRef[1])
+	kids := pdfKids.FindStringSubmatch(objects[pagesNumber].Dict)
+	if kids == nil {
+		return nil, errors.New("the page tree has no kids")
+	}
+	pages := pdfObject{Dict: kids[1]}.references()
+	if field.Page < 1 || field.Page > len(pages) {
+		return nil, fmt.Errorf("page %d does not exist", field.Page)
+	}
+	pageNumber := pages[field.Page-1]
+	next := 0
+	for number := range objects {
+		if number >= next {
+			next = number + 1
+		}
+	}
+	add := func(dict string, stream []byte) int {
+		objects[next] = pdfObject{Number: next, Dict: dict, Stream: stream}
+		next++
+		return next - 1
+	}
+
+	// the certificates make up most of the signature, the rest is well below 4 KB even for large RSA keys
+	size := 4096
+	for _, certificate := range s.chain {
+		size += len(certificate.Raw)
+	}
+	signatureNumber := add(fmt.Sprintf("<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /ETSI.CAdES.detached "+
+		"/M (%s) /ByteRange %s /Contents <%s> >>", pdfDate(signed), byteRangePlaceholder, strings.Repeat("0", 2*size)), nil)
+	rect := field.Rect
+	width, height := rect[2]-rect[0], rect[3]-rect[1]
+	appearanceNumber := add(fmt.Sprintf("<< /Type /XObject /Subtype /Form /BBox [0 0 %.2f %.2f] /Length 0 >>", width, height), []byte{})
+	widgetNumber := add(fmt.Sprintf("<< /Type /Annot /Subtype /Widget /FT /Sig /T (Signature) /F 132 "+
+		"/Rect [%.2f %.2f %.2f %.2f] /P %d 0 R /V %d 0 R /AP << /N %d 0 R >> >>",
+		rect[0], rect[1], rect[2], rect[3], pageNumber, signatureNumber, appearanceNumber), nil)
+
+	page := objects[pageNumber]
+	if location := pdfAnnots.FindStringIndex(page.Dict); location != nil {
+		page.Dict = page.Dict[:location[1]] + fmt.Sprintf("%d 0 R ", widgetNumber) + page.Dict[location[1]:]
+	} else {
+		page.Dict = withEntries(page.Dict, fmt.Sprintf("/Annots [%d 0 R]", widgetNumber))
+	}
+	objects[pageNumber] = page
+	catalog.Dict = withEntries(catalog.Dict, fmt.Sprintf("/AcroForm << /Fields [%d 0 R] /SigFlags 3 >>", widgetNumber))
+	objects[root] = catalog
+
+	id := md5.Sum(data)
+	out := writePdfObjects(string(version[1]), objects, root, info, id[:])
+
+	byteRangeStart := bytes.Index(out, []byte("/ByteRange "+byteRangePlaceholder)) + len("/ByteRange ")
+	contentsStart := byteRangeStart + bytes.Index(out[byteRangeStart:], []byte("/Contents <")) + len("/Contents ")
+	contentsEnd := contentsStart + 2*size + 2
+	byteRange := fmt.Sprintf("[0 %d %d %d]", contentsStart, contentsEnd, len(out)-contentsEnd)
+	copy(out[byteRangeStart:], byteRange+strings.Repeat(" ", len(byteRangePlaceholder)-len(byteRange)))
+
+	digest := sha256.New()
+	digest.Write(out[:contentsStart])
+	digest.Write(out[contentsEnd:])
+	signature, err := signCms(digest.Sum(nil), s.key, s.chain)
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) > size {
+		return nil, fmt.Errorf("the signature takes %d bytes, more than the %d bytes reserved", len(signature), size)
+	}
+	hex.Encode(out[contentsStart+1:], signature)
+	return out, nil
+}
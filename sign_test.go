@@ -0,0 +1,211 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// selfSignedCertificate creates a certificate for key that is valid for a day
+func selfSignedCertificate(t *testing.T, key crypto.Signer) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(4711),
+		Subject:      pkix.Name{CommonName: "Erika Mustermann"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func writePem(t *testing.T, fileName string, blockType string, der []byte) {
+	if err := os.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verifySignature checks that the signature of a signed pdf covers the whole file but the signature itself and
+// was made by certificate. It returns the /Rect of the signature field.
+func verifySignature(t *testing.T, data []byte, certificate *x509.Certificate) string {
+	byteRange := regexp.MustCompile(`/ByteRange \[0 (\d+) (\d+) (\d+) *\]`).FindSubmatch(data)
+	if byteRange == nil {
+		t.Fatal("no /ByteRange found")
+	}
+	ranges := make([]int, 3)
+	for i := range ranges {
+		ranges[i], _ = strconv.Atoi(string(byteRange[i+1]))
+	}
+	AssertEquals(t, ranges[1]+ranges[2], len(data), "end of the second range")
+	AssertEquals(t, string(data[ranges[0]])+string(data[ranges[1]-1]), "<>", "gap delimiters")
+	// the signature is padded with zeros, which asn1.Unmarshal leaves over
+	contents, err := hex.DecodeString(string(data[ranges[0]+1 : ranges[1]-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contentInfo cmsContentInfo
+	if _, err = asn1.Unmarshal(contents, &contentInfo); err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, contentInfo.ContentType.Equal(oidSignedData), true, "content type")
+	var signedData cmsSignedData
+	if _, err = asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, len(signedData.SignerInfos), 1, "signers")
+	AssertEquals(t, bytes.Equal(signedData.Certificates.Bytes, certificate.Raw), true, "embedded certificate")
+	signerInfo := signedData.SignerInfos[0]
+	AssertEquals(t, signerInfo.Sid.SerialNumber.Int64(), int64(4711), "serial number")
+
+	digest := sha256.New()
+	digest.Write(data[:ranges[0]])
+	digest.Write(data[ranges[1]:])
+	var attributes []cmsAttribute
+	if _, err = asn1.UnmarshalWithParams(signerInfo.SignedAttrs.FullBytes, &attributes, "set,tag:0"); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, attribute := range attributes {
+		if attribute.Type.Equal(oidMessageDigest) {
+			var messageDigest []byte
+			if _, err = asn1.Unmarshal(attribute.Values[0].FullBytes, &messageDigest); err != nil {
+				t.Fatal(err)
+			}
+			AssertEquals(t, bytes.Equal(messageDigest, digest.Sum(nil)), true, "message digest")
+			found = true
+		}
+	}
+	AssertEquals(t, found, true, "message digest attribute")
+	signed := append([]byte{0x31}, signerInfo.SignedAttrs.FullBytes[1:]...)
+	algorithm := x509.SHA256WithRSA
+	if _, ok := certificate.PublicKey.(*ecdsa.PublicKey); ok {
+		algorithm = x509.ECDSAWithSHA256
+	}
+	if err = certificate.CheckSignature(algorithm, signed, signerInfo.Signature); err != nil {
+		t.Errorf("invalid signature: %s", err)
+	}
+	rect := regexp.MustCompile(`/FT /Sig .*/Rect \[([^\]]*)\]`).FindSubmatch(data)
+	if rect == nil {
+		t.Fatal("no signature field found")
+	}
+	return string(rect[1])
+}
+
+func TestSignWithPemFiles(t *testing.T) {
+	dir := t.TempDir()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := selfSignedCertificate(t, key)
+	keyFile, certFile := filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	writePem(t, keyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	writePem(t, certFile, "CERTIFICATE", certificate.Raw)
+
+	data := renderPdf(t, minimalLetter, Options{SignKey: keyFile, SignCert: certFile})
+	AssertEquals(t, verifySignature(t, data, certificate), "0.00 0.00 0.00 0.00", "invisible signature")
+
+	// with a signature image the signature field covers it
+	data = renderPdf(t, letterWithConfig(`{"Signature": "./test/it/pdf/Signature.jpg"}`), Options{SignKey: keyFile, SignCert: certFile, PDFA: "2b"})
+	if rect := verifySignature(t, data, certificate); rect == "0.00 0.00 0.00 0.00" {
+		t.Error("the signature is not visible")
+	}
+}
+
+func TestSignWithPkcs12Keystore(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := selfSignedCertificate(t, key)
+	keystore, err := pkcs12.Modern.Encode(key, certificate, nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "keystore.p12")
+	if err = os.WriteFile(keyFile, keystore, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(signPasswordVariable, "secret")
+	data := renderPdf(t, minimalLetter, Options{SignKey: keyFile})
+	verifySignature(t, data, certificate)
+
+	t.Setenv(signPasswordVariable, "wrong")
+	_, err = loadSigner(keyFile, "")
+	AssertEquals(t, err.Error(), "-sign-key: "+keyFile+": pkcs12: decryption password incorrect", "wrong password")
+}
+
+func TestLoadSignerErrors(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, certFile := filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	writePem(t, keyFile, "PRIVATE KEY", keyDer)
+	writePem(t, certFile, "CERTIFICATE", selfSignedCertificate(t, other).Raw)
+
+	signer, err := loadSigner("", "")
+	AssertEquals(t, signer == nil && err == nil, true, "no key")
+	_, err = loadSigner("", certFile)
+	AssertEquals(t, err.Error(), "-sign-cert requires -sign-key", "certificate without key")
+	_, err = loadSigner(filepath.Join(dir, "missing.pem"), "")
+	AssertEquals(t, err.Error(), "-sign-key: "+filepath.Join(dir, "missing.pem")+" not found", "missing key")
+	_, err = loadSigner(keyFile, certFile)
+	AssertEquals(t, err.Error(), "-sign-cert: no certificate matches the key in "+keyFile, "mismatch")
+	_, err = loadSigner(certFile, "")
+	AssertEquals(t, err.Error(), "-sign-key: "+certFile+" contains no private key", "no private key")
+}
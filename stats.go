@@ -0,0 +1,95 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// fontStats describes what a single font contributes to a pdf
+type fontStats struct {
+	Name     string
+	Embedded bool
+	// Size is the number of bytes of the font dictionary and everything it references, e.g. the font file
+	Size int
+}
+
+type pdfStats struct {
+	Size  int
+	Fonts []fontStats
+}
+
+func readPdfStats(data []byte) (pdfStats, error) {
+	objects, err := readPdfObjects(data)
+	if err != nil {
+		return pdfStats{}, err
+	}
+	stats := pdfStats{Size: len(data)}
+	for _, object := range objects {
+		subtype := object.name("Subtype")
+		// descendant fonts are accounted for by the composite font referencing them
+		if object.name("Type") != "Font" || strings.HasPrefix(subtype, "CIDFontType") {
+			continue
+		}
+		font := fontStats{Name: object.name("BaseFont")}
+		for _, number := range reachable(objects, object.Number) {
+			font.Size += objects[number].Size
+			if strings.Contains(objects[number].Dict, "/FontFile") {
+				font.Embedded = true
+			}
+		}
+		stats.Fonts = append(stats.Fonts, font)
+	}
+	sort.Slice(stats.Fonts, func(i, j int) bool {
+		if stats.Fonts[i].Size != stats.Fonts[j].Size {
+			return stats.Fonts[i].Size > stats.Fonts[j].Size
+		}
+		return stats.Fonts[i].Name < stats.Fonts[j].Name
+	})
+	return stats, nil
+}
+
+func printStats(fileName string, stats pdfStats, locale string) string {
+	var sb strings.Builder
+	sb.WriteString(localize(locale, "stats.size", fileName, stats.Size) + "\n")
+	for _, font := range stats.Fonts {
+		if font.Embedded {
+			sb.WriteString("  " + localize(locale, "stats.font", font.Name, font.Size) + "\n")
+		} else {
+			sb.WriteString("  " + localize(locale, "stats.coreFont", font.Name) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+func reportStats(fileName string) (string, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	stats, err := readPdfStats(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", fileName, err)
+	}
+	return printStats(fileName, stats, cliLocale), nil
+}
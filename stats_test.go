@@ -0,0 +1,66 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func renderStats(t *testing.T, letter string) pdfStats {
+	inputFile, err := renderTestLetter(t, letter, "pdf", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := readTestOutput(t, inputFile, "pdf")
+	stats, err := readPdfStats(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, stats.Size, len(data), "size")
+	return stats
+}
+
+func fontNames(stats pdfStats) []string {
+	names := []string{}
+	for _, font := range stats.Fonts {
+		names = append(names, font.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestOnlyUsedFontsAreEmbedded(t *testing.T) {
+	stats := renderStats(t, minimalLetter)
+	AssertStringSliceEquals(t, fontNames(stats), []string{"utf8dejavusanscondensed", "utf8dejavusanscondensedB"}, "fonts")
+	for _, font := range stats.Fonts {
+		AssertEquals(t, font.Embedded, true, font.Name+" embedded")
+		// the complete regular and bold font files take up more than 300 kB each
+		if font.Size > 50000 {
+			t.Errorf("%s takes up %d bytes, it does not seem to be subset", font.Name, font.Size)
+		}
+	}
+
+	stats = renderStats(t, "// config\n{\"FontName\": \"helvetica\", \"FontFallback\": [\"freeserif\"]}\n// address\nName\n// subject\nSubject\n// body\nŁódź\n")
+	AssertStringSliceEquals(t, fontNames(stats), []string{"Helvetica", "Helvetica-Bold", "utf8freeserif"}, "fonts")
+	for _, font := range stats.Fonts {
+		AssertEquals(t, font.Embedded, font.Name == "utf8freeserif", font.Name+" embedded")
+	}
+}
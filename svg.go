@@ -0,0 +1,117 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// renderSvg writes every page of the letter as an svg image. Text is drawn as the outlines of its glyphs, so that the
+// pages look the same without the fonts.
+func renderSvg(inputFile string, defaultConfig Config, options Options) ([]Warning, error) {
+	letter, err := layoutPages(inputFile, defaultConfig, options)
+	if err != nil {
+		return nil, err
+	}
+	for i, page := range letter.layout.Pages {
+		svg, err := letter.svg(page)
+		if err != nil {
+			return nil, err
+		}
+		if err = writeFileAtomic(pageFileName(inputFile, "svg", i+1), []byte(svg), 0644); err != nil {
+			return nil, err
+		}
+	}
+	if err = removeStalePages(inputFile, "svg", len(letter.layout.Pages)); err != nil {
+		return nil, err
+	}
+	return letter.layout.Warnings, nil
+}
+
+// svg returns a page as an svg document, its user units are mm
+func (l pageLetter) svg(page layoutPage) (string, error) {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" width="` + svgNumber(l.width) + `mm" height="` + svgNumber(l.height) + `mm" ` +
+		`viewBox="0 0 ` + svgNumber(l.width) + " " + svgNumber(l.height) + `">` + "\n")
+	if l.title != "" {
+		sb.WriteString("<title>" + xmlText(l.title) + "</title>\n")
+	}
+	sb.WriteString(`<rect width="` + svgNumber(l.width) + `" height="` + svgNumber(l.height) + `" fill="#fff"/>` + "\n")
+	for _, item := range page.Items {
+		switch item := item.(type) {
+		case layoutText:
+			for _, run := range item.Runs {
+				path, err := l.glyphs.outline(item, run)
+				if err != nil {
+					return "", err
+				}
+				if len(path) > 0 {
+					// the label keeps the text readable for screen readers
+					sb.WriteString(`<path aria-label=` + xmlAttribute(run.Text) + ` d="` + svgPathData(path) + `"/>` + "\n")
+				}
+			}
+		case layoutImage:
+			data, err := os.ReadFile(item.File)
+			if err != nil {
+				return "", describeFileError(item.File, err)
+			}
+			// images are signatures, which are jpeg images
+			sb.WriteString(`<image x="` + svgNumber(item.X) + `" y="` + svgNumber(item.Y) + `" width="` + svgNumber(item.Width) + `" ` +
+				`height="` + svgNumber(item.Height) + `" preserveAspectRatio="none" ` +
+				`href="data:image/jpeg;base64,` + base64.StdEncoding.EncodeToString(data) + `"/>` + "\n")
+		case layoutRule:
+			sb.WriteString(`<line x1="` + svgNumber(item.X1) + `" y1="` + svgNumber(item.Y1) + `" x2="` + svgNumber(item.X2) + `" ` +
+				`y2="` + svgNumber(item.Y2) + `" stroke="#000" stroke-width="` + svgNumber(ruleWidth) + `"/>` + "\n")
+		}
+	}
+	sb.WriteString("</svg>\n")
+	return sb.String(), nil
+}
+
+// svgPathData returns the path data of the d attribute of a path
+func svgPathData(path []pathSegment) string {
+	commands := map[sfnt.SegmentOp]string{sfnt.SegmentOpMoveTo: "M", sfnt.SegmentOpLineTo: "L", sfnt.SegmentOpQuadTo: "Q", sfnt.SegmentOpCubeTo: "C"}
+	var parts []string
+	for _, segment := range path {
+		parts = append(parts, commands[segment.Op])
+		for _, point := range segment.Points {
+			parts = append(parts, svgNumber(point[0]), svgNumber(point[1]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// svgNumber formats a length in mm to a thousandth of a mm
+func svgNumber(mm float64) string {
+	value := math.Round(mm*1000) / 1000
+	if value == 0 {
+		// no -0
+		value = 0
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,240 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var fontFileExtensions = []string{".ttf", ".otf", ".ttc", ".otc"}
+
+type systemFont struct {
+	Family string
+	Style  string
+	Path   string
+	Bold   bool
+	Italic bool
+}
+
+type systemFontFamily struct {
+	Name       string
+	Regular    string
+	Bold       string
+	Italic     string
+	BoldItalic string
+}
+
+// systemFontDirs returns the directories fonts are usually installed to
+func systemFontDirs(goos string) []string {
+	home, _ := os.UserHomeDir()
+	switch goos {
+	case "windows":
+		return []string{
+			filepath.Join(os.Getenv("WINDIR"), "Fonts"),
+			filepath.Join(os.Getenv("LOCALAPPDATA"), "Microsoft", "Windows", "Fonts"),
+		}
+	case "darwin":
+		return []string{"/System/Library/Fonts", "/Library/Fonts", filepath.Join(home, "Library", "Fonts")}
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	dirs := []string{filepath.Join(dataHome, "fonts"), filepath.Join(home, ".fonts")}
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = "/usr/local/share:/usr/share"
+	}
+	for _, dataDir := range filepath.SplitList(dataDirs) {
+		if dataDir != "" {
+			dirs = append(dirs, filepath.Join(dataDir, "fonts"))
+		}
+	}
+	return dirs
+}
+
+// scanFonts recursively reads the names of all font files in dirs. Unreadable files and directories are skipped.
+func scanFonts(dirs []string) []systemFont {
+	var fonts []systemFont
+	for _, dir := range dirs {
+		_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !Contains(fontFileExtensions, strings.ToLower(filepath.Ext(path))) {
+				return nil
+			}
+			fonts = append(fonts, readSystemFonts(path)...)
+			return nil
+		})
+	}
+	return fonts
+}
+
+// readSystemFonts returns the faces of a font file, those of a collection have their index appended to the path. Only
+// the name and head tables are read, not the glyphs that make up most of the file.
+func readSystemFonts(path string) []systemFont {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	header := make([]byte, 12)
+	if _, err = file.ReadAt(header, 0); err != nil {
+		return nil
+	}
+	offsets := []int64{0}
+	collection := string(header[0:4]) == signatureCollection
+	if collection {
+		faces := int64(binary.BigEndian.Uint32(header[8:12]))
+		if 12+4*faces > info.Size() {
+			return nil
+		}
+		directory := make([]byte, 4*faces)
+		if _, err = file.ReadAt(directory, 12); err != nil {
+			return nil
+		}
+		offsets = offsets[:0]
+		for face := int64(0); face < faces; face++ {
+			offsets = append(offsets, int64(binary.BigEndian.Uint32(directory[4*face:])))
+		}
+	}
+	var fonts []systemFont
+	for face, offset := range offsets {
+		facePath := path
+		if collection {
+			facePath = fmt.Sprintf("%s#%d", path, face)
+		}
+		font, err := readTrueTypeTables(file, info.Size(), offset, "name", "head")
+		if err != nil {
+			continue
+		}
+		if systemFont, ok := readSystemFont(font, facePath); ok {
+			fonts = append(fonts, systemFont)
+		}
+	}
+	return fonts
+}
+
+func readSystemFont(font *trueTypeFont, path string) (systemFont, bool) {
+	family, style, err := font.family()
+	if err != nil || family == "" {
+		return systemFont{}, false
+	}
+	bold, italic, err := font.macStyle()
+	if err != nil {
+		return systemFont{}, false
+	}
+	lowerStyle := strings.ToLower(style)
+	return systemFont{
+		Family: family,
+		Style:  style,
+		Path:   path,
+		Bold:   bold || strings.Contains(lowerStyle, "bold"),
+		Italic: italic || strings.Contains(lowerStyle, "italic") || strings.Contains(lowerStyle, "oblique"),
+	}, true
+}
+
+// groupFontFamilies picks the regular, bold and italic faces of each family. If a family has several faces with
+// the same style (e.g. light and regular weights), the one with the most common style name wins.
+func groupFontFamilies(fonts []systemFont) []systemFontFamily {
+	commonStyleNames := []string{"regular", "book", "normal", "roman", "bold", "italic", "oblique", "bold italic", "bold oblique"}
+	sort.Slice(fonts, func(i, j int) bool {
+		iCommon := Contains(commonStyleNames, strings.ToLower(fonts[i].Style))
+		jCommon := Contains(commonStyleNames, strings.ToLower(fonts[j].Style))
+		if iCommon != jCommon {
+			return iCommon
+		}
+		return fonts[i].Path < fonts[j].Path
+	})
+	families := map[string]*systemFontFamily{}
+	for _, font := range fonts {
+		key := strings.ToLower(font.Family)
+		family, ok := families[key]
+		if !ok {
+			family = &systemFontFamily{Name: font.Family}
+			families[key] = family
+		}
+		var face *string
+		switch {
+		case font.Bold && font.Italic:
+			face = &family.BoldItalic
+		case font.Bold:
+			face = &family.Bold
+		case font.Italic:
+			face = &family.Italic
+		default:
+			face = &family.Regular
+		}
+		if *face == "" {
+			*face = font.Path
+		}
+	}
+	var result []systemFontFamily
+	for _, family := range families {
+		if family.Regular != "" {
+			result = append(result, *family)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Name) < strings.ToLower(result[j].Name)
+	})
+	return result
+}
+
+func findFontFamily(families []systemFontFamily, name string) (systemFontFamily, bool) {
+	for _, family := range families {
+		if strings.EqualFold(family.Name, name) {
+			return family, true
+		}
+	}
+	return systemFontFamily{}, false
+}
+
+// fontImport describes how to import the faces of the family
+func (f systemFontFamily) fontImport() FontImport {
+	return FontImport{
+		Name:                   f.Name,
+		FontFileName:           f.Regular,
+		FontFileNameBold:       f.Bold,
+		FontFileNameItalic:     f.Italic,
+		FontFileNameBoldItalic: f.BoldItalic,
+	}
+}
+
+func printFontFamilies(families []systemFontFamily) string {
+	var result strings.Builder
+	for _, family := range families {
+		result.WriteString(family.Name + "\n")
+		for _, face := range [][2]string{{"regular", family.Regular}, {"bold", family.Bold}, {"italic", family.Italic}, {"bold italic", family.BoldItalic}} {
+			if face[1] != "" {
+				result.WriteString(fmt.Sprintf("    %-12s %s\n", face[0], face[1]))
+			}
+		}
+	}
+	return result.String()
+}
@@ -0,0 +1,84 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindInstalledFontFamily(t *testing.T) {
+	families := groupFontFamilies(scanFonts([]string{"./test/fonts", "./test/does-not-exist"}))
+	family, found := findFontFamily(families, "noto sans condensed")
+	if !found {
+		t.Fatalf("Font family not found in %v", families)
+	}
+	AssertEquals(t, family.Name, "Noto Sans Condensed", "Name")
+	AssertEquals(t, family.Regular, "test/fonts/NotoSans-Condensed.ttf", "Regular")
+	AssertEquals(t, family.Bold, "test/fonts/NotoSans-CondensedBold.ttf", "Bold")
+	AssertEquals(t, family.Italic, "", "Italic")
+	fontImport := family.fontImport()
+	AssertEquals(t, fontImport.fileName("BI"), "test/fonts/NotoSans-CondensedBold.ttf", "bold italic face")
+	AssertEquals(t, fontImport.fileName("I"), "test/fonts/NotoSans-Condensed.ttf", "italic face")
+}
+
+func TestReadTrueTypeTables(t *testing.T) {
+	data, err := os.ReadFile("test/fonts/NotoSans-CondensedBold.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	font, err := readTrueTypeTables(bytes.NewReader(data), int64(len(data)), 0, "name", "head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, len(font.tables), 2, "tables")
+	AssertEquals(t, len(font.data) < len(data)/10, true, "only the tables are read")
+	family, style, _ := font.family()
+	AssertEquals(t, family+" "+style, "Noto Sans Condensed Bold", "name")
+	bold, italic, _ := font.macStyle()
+	AssertEquals(t, bold && !italic, true, "style")
+	_, err = readTrueTypeTables(bytes.NewReader(data[:300]), 300, 0, "name", "head")
+	AssertEquals(t, err.Error(), `table "head" exceeds the file size`, "truncated file")
+
+	// a collection claiming more faces than fit into the file
+	broken := filepath.Join(t.TempDir(), "broken.ttc")
+	if err = os.WriteFile(broken, []byte("ttcf\x00\x01\x00\x00\xff\xff\xff\xff"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, len(readSystemFonts(broken)), 0, "faces of a broken collection")
+}
+
+func TestRenderWithInstalledFont(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "./test")
+	t.Setenv("XDG_DATA_DIRS", "/nonexistent")
+	t.Setenv("HOME", t.TempDir())
+	inputFile := writeTestLetter(t, letterWithConfig(`{"FontName": "Noto Sans Condensed", "FontFallback": ["DejaVu Sans"]}`))
+	_, err := render(inputFile, defaultConfig, Options{})
+	AssertEquals(t, err.Error(), `FontFallback: unknown font "DejaVu Sans", it is neither embedded, imported, a core font nor installed`, "error")
+
+	if err = os.WriteFile(inputFile, []byte(letterWithConfig(`{"FontName": "Noto Sans Condensed"}`)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = render(inputFile, defaultConfig, Options{}); err != nil {
+		t.Errorf("Rendering with an installed font failed: %s", err)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// renderText writes the letter as plain text, e.g. for the body of an e-mail
+func renderText(inputFile string, defaultConfig Config, options Options) ([]Warning, error) {
+	return nil, writeTextLetter(inputFile, defaultConfig, "txt")
+}
+
+// renderMarkdown writes the letter as markdown, e.g. for a ticket system
+func renderMarkdown(inputFile string, defaultConfig Config, options Options) ([]Warning, error) {
+	return nil, writeTextLetter(inputFile, defaultConfig, "md")
+}
+
+func writeTextLetter(inputFile string, defaultConfig Config, format string) error {
+	content, err := parseLetter(inputFile, defaultConfig)
+	if err != nil {
+		return err
+	}
+	if content.config.TextWidth <= 0 {
+		return &configError{Field: "TextWidth", Err: errors.New("must be greater than 0")}
+	}
+	now, _, err := currentTime()
+	if err != nil {
+		return err
+	}
+	text := textLetter(content, format == "md", content.config.DatePrefix+content.config.resolveDate(now))
+	return writeFileAtomic(formatFileName(inputFile, format), []byte(text), 0644)
+}
+
+/*
+textLetter returns the letter as text, its blocks separated by blank lines: the sender, the recipient, the date, the
+subject, the body, the sender name and the enclosures. The body is reflowed to TextWidth, its blank lines are kept.
+In plain text the date is right aligned, or left aligned for right to left letters. In markdown the subject is a
+heading, line breaks within a block are hard line breaks and characters with a meaning in markdown are escaped.
+*/
+func textLetter(content letter, markdown bool, date string) string {
+	config := content.config
+	var blocks [][]string
+	escape := func(line string) string {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if markdown {
+			return escapeMarkdown(line)
+		}
+		return line
+	}
+	// lines returns the non-empty lines of source, in markdown with hard line breaks between them
+	lines := func(source []string) []string {
+		var result []string
+		for _, line := range source {
+			if line = escape(line); line != "" {
+				result = append(result, line)
+			}
+		}
+		if markdown {
+			for i := 0; i < len(result)-1; i++ {
+				result[i] += "\\"
+			}
+		}
+		return result
+	}
+	blocks = append(blocks, lines(config.Sender), lines(content.recipient))
+	if padding := config.TextWidth - textWidth(date); !markdown && !config.isRightToLeft() && padding > 0 {
+		date = strings.Repeat(" ", padding) + date
+	}
+	blocks = append(blocks, lines([]string{date}))
+	subject := escape(content.subject)
+	if markdown && subject != "" {
+		subject = "## " + subject
+	}
+	blocks = append(blocks, []string{subject})
+
+	body := content.text
+	for len(body) > 0 && strings.TrimSpace(body[0]) == "" {
+		body = body[1:]
+	}
+	for len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "" {
+		body = body[:len(body)-1]
+	}
+	// blank lines separate paragraphs, every other line of the letter starts a new line
+	var paragraph []string
+	for _, line := range append(body, "") {
+		if strings.TrimSpace(line) != "" {
+			paragraph = append(paragraph, line)
+			continue
+		}
+		var wrapped []string
+		for i, line := range paragraph {
+			if markdown && i > 0 {
+				wrapped[len(wrapped)-1] += "\\"
+			}
+			for _, part := range wrapText(line, config.TextWidth) {
+				wrapped = append(wrapped, escape(part))
+			}
+		}
+		blocks = append(blocks, wrapped)
+		paragraph = nil
+	}
+	blocks = append(blocks, lines([]string{config.GetSenderNameOrEmpty()}))
+	if len(config.Enclosures) > 0 {
+		label := localize(resolveLocale(config.Locale), "letter.enclosures")
+		blocks = append(blocks, lines([]string{label + " " + strings.Join(MapStrings(config.Enclosures, enclosureName), ", ")}))
+	}
+
+	var sb strings.Builder
+	for _, block := range blocks {
+		if len(block) == 0 || len(block) == 1 && block[0] == "" {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(strings.Join(block, "\n") + "\n")
+	}
+	return sb.String()
+}
+
+// wrapText breaks text into lines of at most width characters at spaces. Longer words, e.g. links, stay unbroken.
+func wrapText(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && textWidth(line)+1+textWidth(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	return append(lines, line)
+}
+
+// textWidth returns the number of characters text takes up, combining marks take up none
+func textWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		if !unicode.Is(unicode.Mn, r) {
+			width++
+		}
+	}
+	return width
+}
+
+// escapeMarkdown escapes the characters of a line that markdown would otherwise interpret
+func escapeMarkdown(line string) string {
+	var sb strings.Builder
+	for _, r := range line {
+		if strings.ContainsRune("\\`*_[]<>|~", r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	escaped := sb.String()
+	trimmed := strings.TrimLeft(escaped, " ")
+	indent := escaped[:len(escaped)-len(trimmed)]
+	// block markers such as headings, quotes, list items and rules are only recognized at the start of a line
+	if trimmed != "" && strings.ContainsRune("#+-=>", rune(trimmed[0])) {
+		return indent + "\\" + trimmed
+	}
+	digits := strings.TrimLeft(trimmed, "0123456789")
+	if digits != trimmed && (strings.HasPrefix(digits, ".") || strings.HasPrefix(digits, ")")) {
+		end := len(trimmed) - len(digits)
+		return indent + trimmed[:end] + "\\" + trimmed[end:]
+	}
+	return escaped
+}
@@ -0,0 +1,117 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func textTestLetter(configure func(config *Config)) letter {
+	config := defaultConfig
+	config.Sender = []string{"Me", "Here 1"}
+	config.TextWidth = 30
+	senderName := "Me"
+	config.SenderName = &senderName
+	config.Enclosures = []string{"scans/invoice_42.pdf"}
+	config.Locale = "en"
+	configure(&config)
+	return letter{
+		config:    config,
+		recipient: []string{"You", "", "There 2 "},
+		subject:   "About *this*",
+		text: []string{"", "Dear you,", "", "this line is long enough to be wrapped at thirty characters.",
+			"- not a list", "", "", "Regards,", ""},
+	}
+}
+
+func TestTextLetter(t *testing.T) {
+	AssertEquals(t, textLetter(textTestLetter(func(*Config) {}), false, "City, 01.06.2023"), `Me
+Here 1
+
+You
+There 2
+
+              City, 01.06.2023
+
+About *this*
+
+Dear you,
+
+this line is long enough to be
+wrapped at thirty characters.
+- not a list
+
+Regards,
+
+Me
+
+Enclosures: invoice_42
+`, "plain text")
+	rightToLeft := textLetter(textTestLetter(func(config *Config) { config.Direction = "rtl" }), false, "City, 01.06.2023")
+	AssertEquals(t, strings.Contains(rightToLeft, "\n\nCity, 01.06.2023\n\n"), true, "no alignment right to left")
+}
+
+func TestMarkdownLetter(t *testing.T) {
+	AssertEquals(t, textLetter(textTestLetter(func(*Config) {}), true, "City, 01.06.2023"), `Me\
+Here 1
+
+You\
+There 2
+
+City, 01.06.2023
+
+## About \*this\*
+
+Dear you,
+
+this line is long enough to be
+wrapped at thirty characters.\
+\- not a list
+
+Regards,
+
+Me
+
+Enclosures: invoice\_42
+`, "markdown")
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	AssertEquals(t, escapeMarkdown("# 1. <b>_a_|b~`c`\\"), "\\# 1. \\<b\\>\\_a\\_\\|b\\~\\`c\\`\\\\", "special characters")
+	AssertEquals(t, escapeMarkdown("  > quote"), "  \\> quote", "quote")
+	AssertEquals(t, escapeMarkdown("12) item"), "12\\) item", "ordered list")
+	AssertEquals(t, escapeMarkdown("+ item - not"), "\\+ item - not", "unordered list")
+}
+
+func TestWrapText(t *testing.T) {
+	AssertStringSliceEquals(t, wrapText("one two  three", 7), []string{"one two", "three"}, "wrapped")
+	AssertStringSliceEquals(t, wrapText("see https://example.com/a/long/link", 10), []string{"see", "https://example.com/a/long/link"}, "long words")
+	AssertStringSliceEquals(t, wrapText("", 10), []string{""}, "empty")
+}
+
+func TestTextWidthError(t *testing.T) {
+	inputFile, err := renderTestLetter(t, letterWithConfig(`{"TextWidth": 0}`), "txt", Options{})
+	AssertEquals(t, err.Error(), "TextWidth: must be greater than 0", "error")
+	if _, err = renderMarkdown(filepath.Join(filepath.Dir(inputFile), "missing.left"), defaultConfig, Options{}); err == nil {
+		t.Error("expected an error for a missing letter")
+	}
+}
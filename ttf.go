@@ -0,0 +1,396 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+type fontTable struct {
+	offset uint32
+	length uint32
+}
+
+// trueTypeFont gives access to the tables of a TrueType or OpenType font file.
+// Only the few tables left needs to inspect fonts are decoded.
+type trueTypeFont struct {
+	data   []byte
+	tables map[string]fontTable
+}
+
+const (
+	signatureTrueType   = "\x00\x01\x00\x00"
+	signatureApple      = "true"
+	signatureOpenType   = "OTTO"
+	signatureCollection = "ttcf"
+)
+
+func parseTrueType(data []byte) (*trueTypeFont, error) {
+	return parseTrueTypeFace(data, 0)
+}
+
+// parseTrueTypeFace parses the face with the given index of a TrueType collection. For single fonts, the index
+// must be 0.
+func parseTrueTypeFace(data []byte, face int) (*trueTypeFont, error) {
+	if len(data) < 12 {
+		return nil, errors.New("file is too short to be a font")
+	}
+	offset := 0
+	if string(data[0:4]) == signatureCollection {
+		faces := collectionFaces(data)
+		if face < 0 || face >= faces {
+			return nil, fmt.Errorf("font collection has no face %d, it has %d faces", face, faces)
+		}
+		offset = int(binary.BigEndian.Uint32(data[12+4*face:]))
+		if offset+12 > len(data) {
+			return nil, fmt.Errorf("face %d exceeds the file size", face)
+		}
+	} else if face != 0 {
+		return nil, fmt.Errorf("face %d requested, but the file is not a font collection", face)
+	}
+	switch string(data[offset : offset+4]) {
+	case signatureTrueType, signatureApple, signatureOpenType:
+	default:
+		return nil, fmt.Errorf("unsupported font format (signature %q)", data[offset:offset+4])
+	}
+	numTables := int(binary.BigEndian.Uint16(data[offset+4 : offset+6]))
+	if len(data) < offset+12+16*numTables {
+		return nil, errors.New("truncated table directory")
+	}
+	font := &trueTypeFont{data: data, tables: make(map[string]fontTable, numTables)}
+	for i := 0; i < numTables; i++ {
+		record := data[offset+12+16*i : offset+12+16*(i+1)]
+		table := fontTable{
+			offset: binary.BigEndian.Uint32(record[8:12]),
+			length: binary.BigEndian.Uint32(record[12:16]),
+		}
+		if uint64(table.offset)+uint64(table.length) > uint64(len(data)) {
+			return nil, fmt.Errorf("table %q exceeds the file size", record[0:4])
+		}
+		font.tables[string(record[0:4])] = table
+	}
+	return font, nil
+}
+
+// readTrueTypeTables reads only the given tables of the font starting at offset in file, e.g. a face of a collection,
+// instead of the whole file. size is the size of the file. The other tables are missing from the returned font.
+func readTrueTypeTables(file io.ReaderAt, size int64, offset int64, tags ...string) (*trueTypeFont, error) {
+	header := make([]byte, 12)
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return nil, errors.New("file is too short to be a font")
+	}
+	switch string(header[0:4]) {
+	case signatureTrueType, signatureApple, signatureOpenType:
+	default:
+		return nil, fmt.Errorf("unsupported font format (signature %q)", header[0:4])
+	}
+	numTables := int(binary.BigEndian.Uint16(header[4:6]))
+	directory := make([]byte, 16*numTables)
+	if _, err := file.ReadAt(directory, offset+12); err != nil {
+		return nil, errors.New("truncated table directory")
+	}
+	font := &trueTypeFont{tables: make(map[string]fontTable, len(tags))}
+	for i := 0; i < numTables; i++ {
+		record := directory[16*i : 16*(i+1)]
+		tag := string(record[0:4])
+		if !Contains(tags, tag) {
+			continue
+		}
+		tableOffset, length := int64(binary.BigEndian.Uint32(record[8:12])), int64(binary.BigEndian.Uint32(record[12:16]))
+		if tableOffset+length > size {
+			return nil, fmt.Errorf("table %q exceeds the file size", tag)
+		}
+		table := make([]byte, length)
+		if _, err := file.ReadAt(table, tableOffset); err != nil {
+			return nil, err
+		}
+		font.tables[tag] = fontTable{offset: uint32(len(font.data)), length: uint32(length)}
+		font.data = append(font.data, table...)
+	}
+	return font, nil
+}
+
+// collectionFaces returns the number of faces in a TrueType collection, or 1 for a single font
+func collectionFaces(data []byte) int {
+	if len(data) < 12 || string(data[0:4]) != signatureCollection {
+		return 1
+	}
+	faces := int(binary.BigEndian.Uint32(data[8:12]))
+	if len(data) < 12+4*faces {
+		return 0
+	}
+	return faces
+}
+
+func (f *trueTypeFont) table(tag string) ([]byte, error) {
+	table, ok := f.tables[tag]
+	if !ok {
+		return nil, fmt.Errorf("missing %q table", tag)
+	}
+	return f.data[table.offset : table.offset+table.length], nil
+}
+
+// hasOutlines reports whether the font has TrueType outlines, as opposed to CFF ones
+func (f *trueTypeFont) hasOutlines() bool {
+	_, glyf := f.tables["glyf"]
+	_, loca := f.tables["loca"]
+	return glyf && loca
+}
+
+// assembleFont writes a font file with the given signature consisting of the given tables
+func assembleFont(signature string, tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= len(tags) {
+		entrySelector++
+	}
+	header := make([]byte, 12+16*len(tags))
+	copy(header, signature)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(tags)))
+	binary.BigEndian.PutUint16(header[6:], uint16(16<<entrySelector))
+	binary.BigEndian.PutUint16(header[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(header[10:], uint16(16*len(tags)-16<<entrySelector))
+	var body []byte
+	for i, tag := range tags {
+		table := tables[tag]
+		record := header[12+16*i:]
+		copy(record[0:4], tag)
+		binary.BigEndian.PutUint32(record[4:], tableChecksum(table))
+		binary.BigEndian.PutUint32(record[8:], uint32(len(header)+len(body)))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(table)))
+		body = append(body, table...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	return append(header, body...)
+}
+
+func tableChecksum(table []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(table); i += 4 {
+		var word [4]byte
+		copy(word[:], table[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
+
+// runes returns the set of characters the font has glyphs for, according to its unicode cmap
+func (f *trueTypeFont) runes() (map[rune]bool, error) {
+	format4, format12, err := f.unicodeCmaps()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case format12 != nil:
+		return parseCmapFormat12(format12)
+	case format4 != nil:
+		return parseCmapFormat4(format4)
+	default:
+		return nil, errors.New("no unicode cmap subtable in format 4 or 12")
+	}
+}
+
+// unicodeCmaps returns the unicode cmap subtables in format 4 and 12, either may be nil
+func (f *trueTypeFont) unicodeCmaps() ([]byte, []byte, error) {
+	cmap, err := f.table("cmap")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cmap) < 4 {
+		return nil, nil, errors.New("truncated cmap table")
+	}
+	numSubtables := int(binary.BigEndian.Uint16(cmap[2:4]))
+	var format4, format12 []byte
+	for i := 0; i < numSubtables; i++ {
+		if len(cmap) < 4+8*(i+1) {
+			return nil, nil, errors.New("truncated cmap table")
+		}
+		record := cmap[4+8*i:]
+		platform := binary.BigEndian.Uint16(record[0:2])
+		encoding := binary.BigEndian.Uint16(record[2:4])
+		offset := binary.BigEndian.Uint32(record[4:8])
+		if platform != 0 && !(platform == 3 && (encoding == 1 || encoding == 10)) {
+			continue
+		}
+		if uint64(offset)+2 > uint64(len(cmap)) {
+			return nil, nil, errors.New("cmap subtable exceeds the cmap table")
+		}
+		subtable := cmap[offset:]
+		switch binary.BigEndian.Uint16(subtable[0:2]) {
+		case 4:
+			format4 = subtable
+		case 12:
+			format12 = subtable
+		}
+	}
+	return format4, format12, nil
+}
+
+func parseCmapFormat4(subtable []byte) (map[rune]bool, error) {
+	if len(subtable) < 14 {
+		return nil, errors.New("truncated cmap subtable")
+	}
+	segCount := int(binary.BigEndian.Uint16(subtable[6:8])) / 2
+	if len(subtable) < 16+8*segCount {
+		return nil, errors.New("truncated cmap subtable")
+	}
+	endCodes := subtable[14:]
+	startCodes := subtable[16+2*segCount:]
+	idDeltas := subtable[16+4*segCount:]
+	idRangeOffsets := subtable[16+6*segCount:]
+	result := make(map[rune]bool)
+	for segment := 0; segment < segCount; segment++ {
+		end := int(binary.BigEndian.Uint16(endCodes[2*segment:]))
+		start := int(binary.BigEndian.Uint16(startCodes[2*segment:]))
+		delta := int(binary.BigEndian.Uint16(idDeltas[2*segment:]))
+		rangeOffset := int(binary.BigEndian.Uint16(idRangeOffsets[2*segment:]))
+		for code := start; code <= end && code != 0xFFFF; code++ {
+			glyph := 0
+			if rangeOffset == 0 {
+				glyph = (code + delta) & 0xFFFF
+			} else {
+				// idRangeOffset is relative to its own position in the subtable
+				position := 16 + 6*segCount + 2*segment + rangeOffset + 2*(code-start)
+				if position+2 > len(subtable) {
+					continue
+				}
+				glyph = int(binary.BigEndian.Uint16(subtable[position:]))
+				if glyph != 0 {
+					glyph = (glyph + delta) & 0xFFFF
+				}
+			}
+			if glyph != 0 {
+				result[rune(code)] = true
+			}
+		}
+	}
+	return result, nil
+}
+
+func parseCmapFormat12(subtable []byte) (map[rune]bool, error) {
+	if len(subtable) < 16 {
+		return nil, errors.New("truncated cmap subtable")
+	}
+	numGroups := int(binary.BigEndian.Uint32(subtable[12:16]))
+	if len(subtable) < 16+12*numGroups {
+		return nil, errors.New("truncated cmap subtable")
+	}
+	result := make(map[rune]bool)
+	for i := 0; i < numGroups; i++ {
+		group := subtable[16+12*i:]
+		start := binary.BigEndian.Uint32(group[0:4])
+		end := binary.BigEndian.Uint32(group[4:8])
+		startGlyph := binary.BigEndian.Uint32(group[8:12])
+		for code := start; code <= end && code <= 0x10FFFF; code++ {
+			if startGlyph+(code-start) != 0 {
+				result[rune(code)] = true
+			}
+		}
+	}
+	return result, nil
+}
+
+const (
+	nameFamily             = 1
+	nameSubfamily          = 2
+	nameTypographicFamily  = 16
+	nameTypographicSubname = 17
+)
+
+// name returns the english entry with the given id from the name table, preferring windows over mac entries
+func (f *trueTypeFont) name(id uint16) (string, error) {
+	table, err := f.table("name")
+	if err != nil {
+		return "", err
+	}
+	if len(table) < 6 {
+		return "", errors.New("truncated name table")
+	}
+	count := int(binary.BigEndian.Uint16(table[2:4]))
+	storage := int(binary.BigEndian.Uint16(table[4:6]))
+	macName := ""
+	for i := 0; i < count; i++ {
+		if len(table) < 6+12*(i+1) {
+			return "", errors.New("truncated name table")
+		}
+		record := table[6+12*i:]
+		platform := binary.BigEndian.Uint16(record[0:2])
+		language := binary.BigEndian.Uint16(record[4:6])
+		if binary.BigEndian.Uint16(record[6:8]) != id {
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(record[8:10]))
+		offset := storage + int(binary.BigEndian.Uint16(record[10:12]))
+		if offset+length > len(table) {
+			return "", errors.New("name exceeds the name table")
+		}
+		value := table[offset : offset+length]
+		switch {
+		case platform == 3 && language == 0x0409:
+			runes := make([]rune, 0, length/2)
+			for j := 0; j+1 < len(value); j += 2 {
+				runes = append(runes, rune(binary.BigEndian.Uint16(value[j:])))
+			}
+			return string(runes), nil
+		case platform == 1 && language == 0 && macName == "":
+			macName = string(value)
+		}
+	}
+	return macName, nil
+}
+
+// family returns the family and subfamily (style) name of the font
+func (f *trueTypeFont) family() (string, string, error) {
+	family, err := f.name(nameTypographicFamily)
+	if err == nil && family == "" {
+		family, err = f.name(nameFamily)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	subfamily, err := f.name(nameTypographicSubname)
+	if err == nil && subfamily == "" {
+		subfamily, err = f.name(nameSubfamily)
+	}
+	return family, subfamily, err
+}
+
+// macStyle returns whether the head table marks the font as bold and italic
+func (f *trueTypeFont) macStyle() (bool, bool, error) {
+	head, err := f.table("head")
+	if err != nil {
+		return false, false, err
+	}
+	if len(head) < 46 {
+		return false, false, errors.New("truncated head table")
+	}
+	style := binary.BigEndian.Uint16(head[44:46])
+	return style&1 != 0, style&2 != 0, nil
+}
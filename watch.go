@@ -0,0 +1,170 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchInterval is how often -watch looks for changed files. A change is only acted upon once the files have stayed
+// the same for another interval, as editors often save a file in several steps.
+const watchInterval = 250 * time.Millisecond
+
+// fileState is what tells a changed file apart, files that do not exist yet are watched too
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// letterWatcher renders a letter again whenever one of the files it depends on changes
+type letterWatcher struct {
+	inputFile string
+	// configPaths looks up the config files, on every check, so that config files created later are found too
+	configPaths func() []string
+	options     Options
+	render      func(inputFile string, defaultConfig Config, options Options) ([]Warning, error)
+	// report is told the outcome of every rendering
+	report func(warnings []Warning, err error)
+	// watched are the files the letter depended on when it was last rendered
+	watched []string
+	files   map[string]fileState
+	// pending is set when files changed, until the letter is rendered again
+	pending bool
+}
+
+// watchLetter renders a letter and renders it again on every change until the process is stopped
+func watchLetter(inputFile string, configPaths func() []string, options Options,
+	render func(inputFile string, defaultConfig Config, options Options) ([]Warning, error)) {
+	watcher := &letterWatcher{inputFile: inputFile, configPaths: configPaths, options: options, render: render,
+		report: func(warnings []Warning, err error) {
+			for _, warning := range warnings {
+				printWarning(warning.String())
+			}
+			if err != nil {
+				printError(err.Error())
+			} else {
+				fmt.Println(localize(cliLocale, "watch.rendered", inputFile, time.Now().Format("15:04:05")))
+			}
+		}}
+	fmt.Println(localize(cliLocale, "watch.started", inputFile))
+	watcher.run(time.NewTicker(watchInterval).C)
+}
+
+// run renders the letter, then checks its files on every tick
+func (w *letterWatcher) run(ticks <-chan time.Time) {
+	w.renderLetter()
+	for range ticks {
+		w.check()
+	}
+}
+
+// check renders the letter if its files changed before the previous check and have stayed the same since. Only the
+// config files are looked up again, the other files are those of the last rendering.
+func (w *letterWatcher) check() {
+	files := append([]string{}, w.watched...)
+	for _, file := range w.configPaths() {
+		if !Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	current := fileStates(files)
+	if !sameFileStates(current, w.files) {
+		w.files = current
+		w.pending = true
+	} else if w.pending {
+		w.pending = false
+		w.renderLetter()
+	}
+}
+
+// renderLetter renders the letter with the current config files. The files are looked at before, so that changes
+// made while rendering cause another rendering.
+func (w *letterWatcher) renderLetter() {
+	configPaths := w.configPaths()
+	w.watched = watchedFiles(w.inputFile, configPaths)
+	w.files = fileStates(w.watched)
+	defaultConfig, err := loadDefaultConfig(configPaths)
+	if err != nil {
+		w.report(nil, err)
+		return
+	}
+	w.report(w.render(w.inputFile, defaultConfig, w.options))
+}
+
+// watchedFiles returns the files the letter depends on: the letter itself, the config files and the files its
+// config refers to, i.e. the signature, imported fonts, enclosures and attachments
+func watchedFiles(inputFile string, configPaths []string) []string {
+	files := append([]string{inputFile}, configPaths...)
+	add := func(file string) {
+		if file != "" && !Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	defaultConfig, err := loadDefaultConfig(configPaths)
+	if err != nil {
+		return files
+	}
+	config := defaultConfig
+	if content, err := parseLetter(inputFile, defaultConfig); err == nil {
+		config = content.config
+	}
+	add(config.GetSignatureOrEmpty())
+	if fontImport := config.FontImport; fontImport != nil {
+		for _, style := range []string{"", "B", "I", "BI"} {
+			if fileName := fontImport.fileName(style); fileName != "" {
+				file, _ := splitFaceIndex(filepath.Join(fontImport.Directory, fileName))
+				add(file)
+			}
+		}
+	}
+	for _, file := range append(config.Enclosures, config.Attachments...) {
+		add(file)
+	}
+	return files
+}
+
+func fileStates(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			states[file] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+		} else {
+			states[file] = fileState{}
+		}
+	}
+	return states
+}
+
+func sameFileStates(a map[string]fileState, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, state := range a {
+		other, found := b[file]
+		if !found || state.exists != other.exists || state.size != other.size || !state.modTime.Equal(other.modTime) {
+			return false
+		}
+	}
+	return true
+}
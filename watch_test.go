@@ -0,0 +1,118 @@
+/*
+ *  Copyright 2023, Enguerrand de Rochefort
+ *
+ * This file is part of left.
+ *
+ * left is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * left is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with left.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLetterWatcher(t *testing.T) {
+	dir := t.TempDir()
+	inputFile := filepath.Join(dir, "letter.left")
+	signature := filepath.Join(dir, "signature.jpg")
+	configFile := filepath.Join(dir, "defaults.json")
+	write := func(file string, content string) {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(inputFile, "// config\n{\"Signature\": \""+signature+"\"}\n// address\nYou\n// subject\nHi\n// body\nText\n")
+	write(signature, "jpeg")
+
+	var outcomes []error
+	var senderName string
+	configPaths := []string{configFile}
+	watcher := &letterWatcher{inputFile: inputFile, configPaths: func() []string { return configPaths },
+		render: func(_ string, defaultConfig Config, _ Options) ([]Warning, error) {
+			senderName = defaultConfig.GetSenderNameOrEmpty()
+			if len(outcomes) == 1 {
+				return nil, errors.New("broken")
+			}
+			return nil, nil
+		},
+		report: func(warnings []Warning, err error) {
+			outcomes = append(outcomes, err)
+		}}
+
+	check := func(count int) {
+		for i := 0; i < count; i++ {
+			watcher.check()
+		}
+	}
+
+	watcher.renderLetter()
+	AssertStringSliceEquals(t, watcher.watched, []string{inputFile, configFile, signature}, "watched files")
+	check(2)
+	AssertEquals(t, len(outcomes), 1, "renderings without changes")
+	write(inputFile, "// config\n{\"Signature\": \""+signature+"\"}\n// address\nYou\n// subject\nHello\n// body\nText\n")
+	check(1)
+	AssertEquals(t, len(outcomes), 1, "renderings right after a change")
+	check(1)
+	AssertEquals(t, len(outcomes), 2, "renderings after the letter changed")
+	write(signature, "another jpeg")
+	check(3)
+	AssertEquals(t, len(outcomes), 3, "renderings after the signature changed")
+	// a config file that did not exist before
+	write(configFile, "{}")
+	check(3)
+	AssertEquals(t, len(outcomes), 4, "renderings after a config file was created")
+	// a project config file that is found only now
+	projectFile := filepath.Join(dir, projectConfigFileName)
+	write(projectFile, `{"SenderName": "Project"}`)
+	configPaths = append(configPaths, projectFile)
+	check(3)
+	AssertEquals(t, len(outcomes), 5, "renderings after a project config file was found")
+	AssertEquals(t, senderName, "Project", "sender name of the project config file")
+	AssertStringSliceEquals(t, watcher.watched, []string{inputFile, configFile, projectFile, signature},
+		"watched files with the project config file")
+
+	AssertEquals(t, outcomes[1].Error(), "broken", "error of the second rendering")
+	AssertEquals(t, outcomes[2], nil, "the watcher keeps rendering after an error")
+}
+
+func TestWatchedFilesOfFontImport(t *testing.T) {
+	inputFile := writeTestLetter(t, letterWithConfig(`{"FontImport": {"Name": "Noto", "Directory": "fonts", `+
+		`"FontFileName": "Noto.ttc#1", "FontFileNameBold": "NotoBold.ttf"}, "Enclosures": ["invoice.pdf"]}`))
+	AssertStringSliceEquals(t, watchedFiles(inputFile, nil),
+		[]string{inputFile, filepath.Join("fonts", "Noto.ttc"), filepath.Join("fonts", "NotoBold.ttf"), "invoice.pdf"}, "watched files")
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "letter.pdf")
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(fileName, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		AssertEquals(t, string(data), content, "content")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEquals(t, len(entries), 1, "files left behind")
+}